@@ -13,10 +13,13 @@ type Info struct {
 	Err  error
 }
 
-func Hardware(conn *dbus.Conn) []Info {
+// Offline skips the network check, for updates coming from local sources
+func Hardware(conn *dbus.Conn, offline bool) []Info {
 	var checks []Info
 	checks = append(checks, battery(conn))
-	checks = append(checks, network(conn))
+	if !offline {
+		checks = append(checks, network(conn))
+	}
 	checks = append(checks, cpu())
 	checks = append(checks, memory())
 
@@ -80,24 +83,14 @@ func battery(conn *dbus.Conn) Info {
 	}
 }
 
-func network(conn *dbus.Conn) Info {
-	const name string = "Network"
-
-	nm := conn.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager")
-
+func metered(nm dbus.BusObject) (bool, error) {
 	variant, err := nm.GetProperty("org.freedesktop.NetworkManager.Metered")
 	if err != nil {
-		return Info{
-			name,
-			err,
-		}
+		return false, err
 	}
 	metered, ok := variant.Value().(uint32)
 	if !ok {
-		return Info{
-			name,
-			fmt.Errorf("Unable to determine if network connection is metered from: %v", variant),
-		}
+		return false, fmt.Errorf("Unable to determine if network connection is metered from: %v", variant)
 	}
 	// The possible values of "Metered" are documented here:
 	// https://networkmanager.dev/docs/api/latest/nm-dbus-types.html//NMMetered
@@ -108,7 +101,32 @@ func network(conn *dbus.Conn) Info {
 	//     NM_METERED_GUESS_YES = 3 // Metered, the value was guessed
 	//     NM_METERED_GUESS_NO  = 4 // Not metered, the value was guessed
 	//
-	if metered == 1 || metered == 3 {
+	return metered == 1 || metered == 3, nil
+}
+
+// Whether NetworkManager considers the current connection metered
+func Metered() (bool, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	return metered(conn.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager"))
+}
+
+func network(conn *dbus.Conn) Info {
+	const name string = "Network"
+
+	nm := conn.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager")
+
+	isMetered, err := metered(nm)
+	if err != nil {
+		return Info{
+			name,
+			err,
+		}
+	}
+	if isMetered {
 		return Info{
 			name,
 			fmt.Errorf("Network is metered"),
@@ -184,14 +202,14 @@ func cpu() Info {
 	}
 }
 
-func RunHwChecks() error {
+func RunHwChecks(offline bool) error {
 	// (some hardware checks require dbus access)
 	conn, err := dbus.SystemBus()
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-	checkInfo := Hardware(conn)
+	checkInfo := Hardware(conn, offline)
 	for _, info := range checkInfo {
 		if info.Err != nil {
 			return fmt.Errorf("%s, returned error: %v", info.Name, info.Err)
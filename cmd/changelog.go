@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/uupd/drv"
+)
+
+func printPackageChanges(title string, changes []drv.PackageChange) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n", title)
+	for _, change := range changes {
+		switch {
+		case change.OldVersion == "":
+			fmt.Printf("  %s.%s %s\n", change.Name, change.Arch, change.NewVersion)
+		case change.NewVersion == "":
+			fmt.Printf("  %s.%s %s\n", change.Name, change.Arch, change.OldVersion)
+		default:
+			fmt.Printf("  %s.%s %s -> %s\n", change.Name, change.Arch, change.OldVersion, change.NewVersion)
+		}
+	}
+}
+
+func printDeployment(title string, deployment drv.Deployment) {
+	fmt.Printf("%s: %s\n", title, deployment.Image)
+	if deployment.Version != "" {
+		fmt.Printf("  Version: %s\n", deployment.Version)
+	}
+	if deployment.Timestamp != "" {
+		fmt.Printf("  Created: %s\n", deployment.Timestamp)
+	}
+	if deployment.Digest != "" {
+		fmt.Printf("  Digest:  %s\n", deployment.Digest)
+	}
+}
+
+func Changelog(cmd *cobra.Command, args []string) {
+	jsonOutput, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		slog.Error("Failed to get json flag", "error", err)
+		return
+	}
+
+	initConfiguration := drv.UpdaterInitConfiguration{}.New()
+	systemUpdater, err := drv.SystemUpdater{}.New(*initConfiguration)
+	if err != nil {
+		slog.Error("Failed getting system driver", slog.Any("error", err))
+		return
+	}
+	var systemDriver drv.SystemUpdateDriver = systemUpdater
+	isBootc, _ := drv.BootcCompatible(systemUpdater.BinaryPath)
+	if !isBootc {
+		systemDriver, err = drv.RpmOstreeUpdater{}.New(*initConfiguration)
+		if err != nil {
+			slog.Error("Failed getting system driver", slog.Any("error", err))
+			return
+		}
+	}
+
+	changelog, err := systemDriver.Changelog()
+	if err != nil {
+		slog.Error("Failed comparing deployments", slog.Any("error", err))
+		return
+	}
+
+	if jsonOutput {
+		out, err := json.Marshal(changelog)
+		if err != nil {
+			slog.Error("Failed encoding changelog", slog.Any("error", err))
+			return
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	if changelog == nil {
+		fmt.Println("No staged or available update")
+		return
+	}
+	printDeployment("Booted", changelog.From)
+	printDeployment("New", changelog.To)
+	if !changelog.PackagesCompared {
+		fmt.Println("Packages can be compared once the update is staged")
+		return
+	}
+	printPackageChanges("Upgraded", changelog.Upgraded)
+	printPackageChanges("Downgraded", changelog.Downgraded)
+	printPackageChanges("Added", changelog.Added)
+	printPackageChanges("Removed", changelog.Removed)
+}
@@ -3,12 +3,17 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/uupd/checks"
+	"github.com/ublue-os/uupd/drv"
 	"log"
 )
 
 func HwCheck(cmd *cobra.Command, args []string) {
 	// (some hardware checks require dbus access)
-	err := checks.RunHwChecks()
+	systemUpdater, err := drv.SystemUpdater{}.New(*drv.UpdaterInitConfiguration{}.New())
+	if err != nil {
+		log.Fatalf("Failed getting system driver: %v", err)
+	}
+	err = checks.RunHwChecks(systemUpdater.Offline())
 	if err != nil {
 		log.Fatalf("Hardware checks failed: %v", err)
 	}
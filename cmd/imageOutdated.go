@@ -1,18 +1,82 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/uupd/drv"
 )
 
+func printImageAge(age *drv.ImageAge) {
+	if age == nil {
+		return
+	}
+	version := age.Version
+	if version == "" {
+		version = "unknown version"
+	}
+	fmt.Printf("%s: %s, built %s (%d days old), outdated: %s\n", age.Deployment, version, age.BuildTime.Local().Format("2006-01-02 15:04"), age.AgeDays, age.Level)
+}
+
+// Age of the booted and staged images, from bootc or rpm-ostree on systems without bootc
+func imageOutdatedReport(initConfiguration *drv.UpdaterInitConfiguration, policy drv.OutdatedPolicy) (drv.OutdatedReport, error) {
+	systemUpdater, err := drv.SystemUpdater{}.New(*initConfiguration)
+	if err != nil {
+		return drv.OutdatedReport{}, err
+	}
+	var systemDriver drv.SystemUpdateDriver = systemUpdater
+	isBootc, _ := drv.BootcCompatible(systemUpdater.BinaryPath)
+	if !isBootc {
+		systemDriver, err = drv.RpmOstreeUpdater{}.New(*initConfiguration)
+		if err != nil {
+			return drv.OutdatedReport{}, err
+		}
+	}
+	return systemDriver.Outdated(policy)
+}
+
 func ImageOutdated(cmd *cobra.Command, args []string) {
-	systemUpdater, err := drv.SystemUpdater{}.New(drv.UpdaterInitConfiguration{})
+	jsonOutput, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		slog.Error("Failed to get json flag", "error", err)
+		return
+	}
+	details, err := cmd.Flags().GetBool("details")
 	if err != nil {
-		slog.Error("Failed getting system driver", slog.Any("error", err))
+		slog.Error("Failed to get details flag", "error", err)
 		return
 	}
 
-	println(systemUpdater.Outdated)
+	initConfiguration := drv.UpdaterInitConfiguration{}.New()
+	policy, err := drv.NewOutdatedPolicy(initConfiguration.Environment)
+	if err != nil {
+		slog.Error("Invalid outdated image policy", slog.Any("error", err))
+		return
+	}
+
+	report, err := imageOutdatedReport(initConfiguration, policy)
+	if err != nil {
+		slog.Error("Failed checking image age", slog.Any("error", err))
+		return
+	}
+
+	if jsonOutput {
+		out, err := json.Marshal(report)
+		if err != nil {
+			slog.Error("Failed encoding report", slog.Any("error", err))
+			return
+		}
+		fmt.Fprintln(os.Stdout, string(out))
+		return
+	}
+
+	// Scripts parse the bare true or false
+	if details {
+		printImageAge(report.Booted)
+		printImageAge(report.Staged)
+	}
+	fmt.Println(report.Level >= drv.OutdatedNotify)
 }
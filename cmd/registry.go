@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"log/slog"
+
+	"github.com/ublue-os/uupd/drv"
+)
+
+// Installs the registry credentials and mirrors for the tools uupd runs, the caller has to hold the lock.
+// Returns the function removing them again.
+func installRegistryConfig(env drv.EnvironmentMap) func() {
+	registryConfig := drv.NewRegistryConfiguration(env)
+	removeRegistryConfig, fallbacks, err := registryConfig.Install()
+	if err != nil {
+		slog.Error("Failed installing registry mirrors and credentials", slog.Any("error", err))
+	}
+	for _, registry := range fallbacks {
+		slog.Warn("Registry unreachable, pulling through its mirrors", slog.String("registry", registry), slog.Any("mirrors", registryConfig.MirrorsOf(registry)))
+	}
+	return removeRegistryConfig
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/ublue-os/uupd/drv"
+	"github.com/ublue-os/uupd/pkg/notify"
+	"github.com/ublue-os/uupd/pkg/report"
+)
+
+func newRunReport(phase string) *report.Report {
+	hostname, err := os.Hostname()
+	if err != nil {
+		slog.Debug("Failed getting hostname", slog.Any("error", err))
+	}
+	return report.New(hostname, phase)
+}
+
+// A deployment staged for the next boot also means a reboot is pending, whichever run staged it
+func recordRebootPending(runReport *report.Report, systemDriver drv.SystemUpdateDriver) {
+	rebootPending, err := systemDriver.RebootPending()
+	if err != nil {
+		slog.Debug("Failed checking for a staged deployment", slog.Any("error", err))
+	}
+	runReport.RebootRequired = runReport.RebootRequired || rebootPending
+}
+
+// Exports what the run did once it is over, however it ended
+func finishRun(env drv.EnvironmentMap, dryRun bool, runReport *report.Report) {
+	runReport.Finish()
+	if dryRun {
+		return
+	}
+
+	metricsDir := env["UUPD_METRICS_DIR"]
+	if metricsDir != "" {
+		err := runReport.WriteTextfile(metricsDir)
+		if err != nil {
+			slog.Error("Failed writing metrics", slog.String("dir", metricsDir), slog.Any("error", err))
+		}
+	}
+
+	subscriptions, err := notify.Subscriptions(env)
+	if err != nil {
+		slog.Error("Invalid notifier configuration", slog.Any("error", err))
+	}
+	previous, err := notify.LoadState()
+	if err != nil {
+		slog.Error("Failed reading notification state, notifying again", slog.Any("error", err))
+	}
+	err = notify.Dispatch(subscriptions, runReport, previous)
+	if err != nil {
+		slog.Error("Failed sending run results", slog.Any("error", err))
+	}
+	err = notify.SaveState(previous.Next(runReport))
+	if err != nil {
+		slog.Error("Failed saving notification state", slog.Any("error", err))
+	}
+}
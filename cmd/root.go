@@ -2,15 +2,19 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"os"
 	"os/user"
 	"path"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/jedib0t/go-pretty/v6/text"
 	"github.com/spf13/cobra"
+	"github.com/ublue-os/uupd/pkg/filelock"
 	appLogging "github.com/ublue-os/uupd/pkg/logging"
 	"golang.org/x/term"
 )
@@ -37,7 +41,7 @@ var (
 
 	waitCmd = &cobra.Command{
 		Use:    "wait",
-		Short:  "Waits for ostree sysroot to unlock",
+		Short:  "Waits for running uupd, bootc and rpm-ostree updates to finish",
 		PreRun: assertRoot,
 		Run:    Wait,
 	}
@@ -58,14 +62,50 @@ var (
 
 	imageOutdatedCmd = &cobra.Command{
 		Use:    "is-img-outdated",
-		Short:  "Print 'true' or 'false' based on if the current booted image is over 1 month old",
+		Short:  "Print 'true' or 'false' based on if the booted image is outdated",
 		PreRun: assertRoot,
 		Run:    ImageOutdated,
 	}
 
+	switchCmd = &cobra.Command{
+		Use:    "switch <image-ref>",
+		Short:  "Switch the system to another signed image",
+		Args:   cobra.ExactArgs(1),
+		PreRun: assertRoot,
+		Run:    Switch,
+	}
+
+	changelogCmd = &cobra.Command{
+		Use:    "changelog",
+		Short:  "Show what changes between the booted and the staged or available image",
+		PreRun: assertRoot,
+		Run:    Changelog,
+	}
+
+	verifyBootCmd = &cobra.Command{
+		Use:    "verify-boot",
+		Short:  "Check the health of the booted image and roll back if it keeps failing",
+		PreRun: assertRoot,
+		Run:    VerifyBoot,
+	}
+
+	statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the maintenance windows, the next slot updates are allowed in and blocked images",
+		Run:   Status,
+	}
+
 	fLogFile   string
 	fLogLevel  string
+	fLogFormat string
+	fColor     string
 	fNoLogging bool
+
+	fLogMaxSize  int64
+	fLogMaxAge   time.Duration
+	fLogKeep     int
+	fLogCompress bool
+	fLogMode     string
 )
 
 func Execute() {
@@ -75,14 +115,49 @@ func Execute() {
 	}
 }
 
+// Takes the uupd lock, waiting for as long as --wait-lock says. Returns nil if that failed.
+func acquireLock(cmd *cobra.Command) *filelock.Lock {
+	timeout, err := cmd.Flags().GetDuration("wait-lock")
+	if err != nil {
+		slog.Error("Failed to get wait-lock flag", "error", err)
+		return nil
+	}
+	lock, err := filelock.AcquireLock(timeout)
+	if err != nil {
+		slog.Error("Failed acquiring lock", slog.String("reason", err.Error()))
+		return nil
+	}
+	if lock.Stale != nil {
+		slog.Warn("Recovered stale lock, previous run didn't exit cleanly", slog.String("owner", lock.Stale.String()), slog.Bool("owner_alive", lock.Stale.Alive()))
+	}
+	return lock
+}
+
+func releaseLock(lock *filelock.Lock) {
+	err := lock.Release()
+	if err != nil {
+		slog.Error("Failed releasing lock", slog.Any("error", err))
+	}
+}
+
 func initLogging(cmd *cobra.Command, args []string) error {
-	var logWriter *os.File = os.Stdout
+	var logWriter io.Writer = os.Stdout
 	if fLogFile != "-" {
 		abs, err := filepath.Abs(path.Clean(fLogFile))
 		if err != nil {
 			return err
 		}
-		logWriter, err = os.OpenFile(abs, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0755)
+		mode, err := strconv.ParseUint(fLogMode, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("Invalid log file mode: %s", fLogMode)
+		}
+		logWriter, err = appLogging.OpenRotatingFile(abs, appLogging.RotationOptions{
+			MaxSize:  fLogMaxSize * 1024 * 1024,
+			MaxAge:   fLogMaxAge,
+			Keep:     fLogKeep,
+			Compress: fLogCompress,
+			Mode:     os.FileMode(mode),
+		})
 		if err != nil {
 			return err
 		}
@@ -93,7 +168,19 @@ func initLogging(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	main_app_logger := slog.New(appLogging.SetupAppLogger(logWriter, logLevel, fLogFile != "-"))
+	err = appLogging.ValidateFormat(fLogFormat)
+	if err != nil {
+		return err
+	}
+	color, err := appLogging.UseColor(fColor, logWriter)
+	if err != nil {
+		return err
+	}
+	if !color {
+		text.DisableColors()
+	}
+
+	main_app_logger := slog.New(appLogging.SetupAppLogger(logWriter, logLevel, fLogFormat, color))
 
 	if fNoLogging {
 		slog.SetDefault(appLogging.NewMuteLogger())
@@ -105,17 +192,40 @@ func initLogging(cmd *cobra.Command, args []string) error {
 
 func init() {
 	rootCmd.AddCommand(waitCmd)
+	waitCmd.Flags().Duration("timeout", 0, "Give up and exit with status 1 after waiting this long, 0 waits forever")
 	rootCmd.AddCommand(updateCheckCmd)
 	rootCmd.AddCommand(hardwareCheckCmd)
 	rootCmd.AddCommand(imageOutdatedCmd)
+	rootCmd.AddCommand(switchCmd)
+	imageOutdatedCmd.Flags().Bool("json", false, "Print the image ages as JSON")
+	imageOutdatedCmd.Flags().Bool("details", false, "Print the age of the booted and staged images first")
+	rootCmd.AddCommand(changelogCmd)
+	changelogCmd.Flags().Bool("json", false, "Print the changelog as JSON")
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(verifyBootCmd)
+	verifyBootCmd.Flags().BoolP("dry-run", "n", false, "Only run the health checks, never roll back")
+	statusCmd.Flags().Bool("json", false, "Print the status as JSON")
+	switchCmd.Flags().BoolP("dry-run", "n", false, "Only verify the image signature policy")
 	rootCmd.Flags().BoolP("hw-check", "c", false, "Run hardware check before running updates")
 	rootCmd.Flags().BoolP("dry-run", "n", false, "Do a dry run")
 	rootCmd.Flags().BoolP("verbose", "v", false, "Display command outputs after run")
 	rootCmd.Flags().Bool("ci", false, "Makes some modifications to behavior if is running in CI")
+	rootCmd.Flags().Bool("fix-conflicts", false, "Disable other updaters that conflict with uupd")
+	rootCmd.Flags().Bool("ignore-windows", false, "Run updates even outside of the maintenance windows")
+	rootCmd.Flags().Bool("wait-for-window", false, "Sleep until the next maintenance window instead of exiting")
+	rootCmd.Flags().String("phase", "", "Only run one phase of the system update: 'download' fetches the new image, 'apply' finalizes it")
 
 	rootCmd.PersistentFlags().StringVar(&fLogFile, "log-file", "-", "File where user-facing logs will be written to")
 	rootCmd.PersistentFlags().StringVar(&fLogLevel, "log-level", "info", "Log level for user-facing logs")
+	rootCmd.PersistentFlags().StringVar(&fLogFormat, "log-format", "auto", "Log format: 'pretty', 'compact' (one line per entry), 'json' or 'auto' (json for --log-file, journald under systemd, pretty otherwise)")
+	rootCmd.PersistentFlags().Int64Var(&fLogMaxSize, "log-max-size", 10, "Rotate --log-file once it grows past this many MiB, 0 disables")
+	rootCmd.PersistentFlags().DurationVar(&fLogMaxAge, "log-max-age", 0, "Rotate --log-file once it is older than this, 0 disables")
+	rootCmd.PersistentFlags().IntVar(&fLogKeep, "log-keep", 5, "Rotated log files to keep")
+	rootCmd.PersistentFlags().BoolVar(&fLogCompress, "log-compress", true, "Compress rotated log files with gzip")
+	rootCmd.PersistentFlags().StringVar(&fLogMode, "log-mode", "0640", "Permissions of the log files")
+	rootCmd.PersistentFlags().StringVar(&fColor, "color", "auto", "Colorize logs: 'auto' (terminals unless NO_COLOR is set), 'always' or 'never'")
 	rootCmd.PersistentFlags().BoolVar(&fNoLogging, "quiet", false, "Make logs quiet")
+	rootCmd.PersistentFlags().Duration("wait-lock", 5*time.Second, "How long to wait for another uupd process to finish, 0 fails right away and a negative value waits forever")
 
 	interactiveProgress := true
 	if fLogFile != "-" {
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/uupd/drv"
+	"github.com/ublue-os/uupd/pkg/filelock"
+	"github.com/ublue-os/uupd/pkg/schedule"
+	"github.com/ublue-os/uupd/pkg/session"
+)
+
+type uupdStatus struct {
+	Windows   []string   `json:"windows"`
+	Blackouts []string   `json:"blackouts"`
+	Allowed   bool       `json:"allowed"`
+	Next      *time.Time `json:"next"`
+	// Another uupd process holding the lock
+	Running   bool            `json:"running"`
+	Owner     *filelock.Owner `json:"owner,omitempty"`
+	Conflicts []drv.Conflict  `json:"conflicts"`
+	// Only known when bootc status can be read
+	BootedBlocked bool             `json:"booted_blocked"`
+	BlockedUpdate *drv.ImageStatus `json:"blocked_update,omitempty"`
+}
+
+func windowSpecs(windows []schedule.Window) []string {
+	specs := []string{}
+	for _, window := range windows {
+		specs = append(specs, window.String())
+	}
+	return specs
+}
+
+// Exits with schedule.ExitOutsideWindow when updates aren't allowed right now,
+// unless waiting for the next window was asked for
+func enforceMaintenanceWindow(cmd *cobra.Command) {
+	ignoreWindows, err := cmd.Flags().GetBool("ignore-windows")
+	if err != nil {
+		slog.Error("Failed to get ignore-windows flag", "error", err)
+		return
+	}
+	waitForWindow, err := cmd.Flags().GetBool("wait-for-window")
+	if err != nil {
+		slog.Error("Failed to get wait-for-window flag", "error", err)
+		return
+	}
+	if ignoreWindows {
+		return
+	}
+
+	initConfiguration := drv.UpdaterInitConfiguration{}.New()
+	policy, err := schedule.ParsePolicy(initConfiguration.Environment)
+	if err != nil {
+		slog.Error("Invalid maintenance windows, ignoring them", slog.Any("error", err))
+		return
+	}
+	now := time.Now()
+	if policy.Allowed(now) {
+		return
+	}
+	if outdatedPolicy, err := drv.NewOutdatedPolicy(initConfiguration.Environment); err == nil {
+		report, err := imageOutdatedReport(initConfiguration, outdatedPolicy)
+		if err == nil && report.Level >= drv.OutdatedForce {
+			slog.Warn("Outside of maintenance windows, updating anyway because the system image is too old", slog.Int("age_days", report.Booted.AgeDays))
+			return
+		}
+	}
+	next, found := policy.Next(now)
+	if !found {
+		slog.Warn("Blackout windows leave no time for updates within the next week, skipping updates")
+		os.Exit(schedule.ExitOutsideWindow)
+	}
+	if !waitForWindow {
+		slog.Info("Outside of maintenance windows, skipping updates", slog.Time("next", next))
+		os.Exit(schedule.ExitOutsideWindow)
+	}
+	slog.Info("Waiting for the next maintenance window", slog.Time("next", next))
+	time.Sleep(time.Until(next))
+}
+
+func Status(cmd *cobra.Command, args []string) {
+	jsonOutput, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		slog.Error("Failed to get json flag", "error", err)
+		return
+	}
+
+	initConfiguration := drv.UpdaterInitConfiguration{}.New()
+	policy, err := schedule.ParsePolicy(initConfiguration.Environment)
+	if err != nil {
+		slog.Error("Invalid maintenance windows", slog.Any("error", err))
+		return
+	}
+	now := time.Now()
+	status := uupdStatus{
+		Windows:   windowSpecs(policy.Windows),
+		Blackouts: windowSpecs(policy.Blackouts),
+		Allowed:   policy.Allowed(now),
+	}
+	next, found := policy.Next(now)
+	if found {
+		status.Next = &next
+	}
+
+	status.Owner, status.Running, err = filelock.CurrentOwner()
+	if err != nil {
+		slog.Error("Failed reading lock", slog.Any("error", err))
+	}
+
+	users, err := session.ListUsers()
+	if err != nil {
+		slog.Debug("Failed listing users, only checking system wide updaters", slog.Any("error", err))
+	}
+	status.Conflicts = drv.DetectConflicts(users)
+	if status.Conflicts == nil {
+		status.Conflicts = []drv.Conflict{}
+	}
+
+	// Doesn't sync the remote blocklist, status has to be quick
+	blocklist, err := drv.LoadBlocklist(initConfiguration.Environment, false)
+	if err != nil {
+		slog.Error("Failed loading image blocklist", slog.Any("error", err))
+	}
+	systemUpdater, err := drv.SystemUpdater{}.New(*initConfiguration)
+	if err == nil {
+		host, err := systemUpdater.Status()
+		if err == nil {
+			status.BootedBlocked = host.Status.Booted != nil && blocklist.Blocks(host.Status.Booted.Image)
+			update := host.AvailableUpdate()
+			if blocklist.Blocks(update) {
+				status.BlockedUpdate = update
+			}
+		}
+	}
+
+	if jsonOutput {
+		out, err := json.Marshal(status)
+		if err != nil {
+			slog.Error("Failed encoding status", slog.Any("error", err))
+			return
+		}
+		fmt.Fprintln(os.Stdout, string(out))
+		return
+	}
+
+	if policy.Empty() {
+		fmt.Println("Maintenance windows: any time")
+	} else {
+		if len(status.Windows) > 0 {
+			fmt.Printf("Maintenance windows: %s\n", strings.Join(status.Windows, "; "))
+		}
+		if len(status.Blackouts) > 0 {
+			fmt.Printf("Blackout windows: %s\n", strings.Join(status.Blackouts, "; "))
+		}
+	}
+	fmt.Printf("Updates allowed now: %t\n", status.Allowed)
+	switch {
+	case status.Allowed:
+		fmt.Println("Next allowed slot: now")
+	case found:
+		fmt.Printf("Next allowed slot: %s\n", next.Format("Mon 2006-01-02 15:04 MST"))
+	default:
+		fmt.Println("Next allowed slot: none within the next week")
+	}
+	switch {
+	case status.Owner != nil:
+		fmt.Printf("Running: %s\n", status.Owner)
+	case status.Running:
+		fmt.Println("Running: yes")
+	}
+	for _, conflict := range status.Conflicts {
+		fmt.Printf("Conflicting updater: %s\n", conflict.Description)
+	}
+	if status.BootedBlocked {
+		fmt.Println("Booted image: blocked, roll back or update as soon as possible")
+	}
+	if status.BlockedUpdate != nil {
+		fmt.Printf("System update: %s available but blocked\n", status.BlockedUpdate.Version)
+	}
+}
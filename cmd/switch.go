@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"log/slog"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/uupd/drv"
+	"github.com/ublue-os/uupd/pkg/session"
+)
+
+func Switch(cmd *cobra.Command, args []string) {
+	lock := acquireLock(cmd)
+	if lock == nil {
+		return
+	}
+	defer releaseLock(lock)
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		slog.Error("Failed to get dry-run flag", "error", err)
+		return
+	}
+
+	initConfiguration := drv.UpdaterInitConfiguration{}.New()
+	defer installRegistryConfig(initConfiguration.Environment)()
+
+	resources, err := session.ParseResources(initConfiguration.Environment)
+	if err != nil {
+		slog.Error("Invalid resource limits, switching with default priority", slog.Any("error", err))
+	}
+	session.SetResources(resources)
+
+	systemUpdater, err := drv.SystemUpdater{}.New(*initConfiguration)
+	if err != nil {
+		slog.Error("Failed getting system driver", slog.Any("error", err))
+		return
+	}
+	// Only verify the signature, don't skip every bootc call like a regular dry run
+	systemUpdater.Config.DryRun = dryRun
+
+	target := drv.ParseImageReference(args[0]).String()
+	current, _, err := systemUpdater.SwitchTarget()
+	if err != nil {
+		slog.Error("Failed getting bootc status", slog.Any("error", err))
+		return
+	}
+	if current == target {
+		slog.Info("Already tracking image", slog.String("image", current))
+		return
+	}
+
+	slog.Info("Switching system image", slog.String("from", current), slog.String("to", target))
+	outputs, err := systemUpdater.Switch(target)
+	if err != nil {
+		slog.Error("Failed switching system image", slog.Any("error", err))
+		for _, output := range *outputs {
+			slog.Info(output.Context, slog.String("stdout", output.Stdout), slog.Any("stderr", output.Stderr), slog.Any("cli", output.Cli))
+		}
+		return
+	}
+	if dryRun {
+		slog.Info("Image signature policy verified, not switching (dry run)")
+		return
+	}
+	slog.Info("System image switched, reboot to apply", slog.String("from", current), slog.String("to", target))
+	_, enforced, err := systemUpdater.SwitchTarget()
+	if err == nil && enforced != "" {
+		slog.Warn("The configured target image differs and will be switched to on the next run", slog.String("target", enforced))
+	}
+}
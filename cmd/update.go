@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"fmt"
 	"log/slog"
 	"os"
 
@@ -9,23 +8,18 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/uupd/checks"
 	"github.com/ublue-os/uupd/drv"
-	"github.com/ublue-os/uupd/pkg/filelock"
 	"github.com/ublue-os/uupd/pkg/percent"
 	"github.com/ublue-os/uupd/pkg/session"
 )
 
 func Update(cmd *cobra.Command, args []string) {
-	lock, err := filelock.AcquireLock()
-	if err != nil {
-		slog.Error(fmt.Sprintf("%v, is uupd already running?", err))
+	enforceMaintenanceWindow(cmd)
+
+	lock := acquireLock(cmd)
+	if lock == nil {
 		return
 	}
-	defer func() {
-		err := filelock.ReleaseLock(lock)
-		if err != nil {
-			slog.Error("Failed releasing lock")
-		}
-	}()
+	defer releaseLock(lock)
 
 	hwCheck, err := cmd.Flags().GetBool("hw-check")
 	if err != nil {
@@ -42,14 +36,15 @@ func Update(cmd *cobra.Command, args []string) {
 		slog.Error("Failed to get verbose flag", "error", err)
 		return
 	}
-
-	if hwCheck {
-		err := checks.RunHwChecks()
-		if err != nil {
-			slog.Error("Hardware checks failed", "error", err)
-			return
-		}
-		slog.Info("Hardware checks passed")
+	phase, err := cmd.Flags().GetString("phase")
+	if err != nil {
+		slog.Error("Failed to get phase flag", "error", err)
+		return
+	}
+	err = drv.ValidatePhase(phase)
+	if err != nil {
+		slog.Error("Invalid phase", "error", err)
+		return
 	}
 
 	users, err := session.ListUsers()
@@ -64,6 +59,33 @@ func Update(cmd *cobra.Command, args []string) {
 	initConfiguration.DryRun = dryRun
 	initConfiguration.Verbose = verboseRun
 
+	runReport := newRunReport(phase)
+	defer finishRun(initConfiguration.Environment, dryRun, runReport)
+
+	resources, err := session.ParseResources(initConfiguration.Environment)
+	if err != nil {
+		slog.Error("Invalid resource limits, running updates with default priority and no download limit", slog.Any("error", err))
+	}
+	session.SetResources(resources)
+
+	fixConflicts, err := cmd.Flags().GetBool("fix-conflicts")
+	if err != nil {
+		slog.Error("Failed to get fix-conflicts flag", "error", err)
+		return
+	}
+	for _, conflict := range drv.DetectConflicts(users) {
+		if !fixConflicts || dryRun {
+			slog.Warn("Conflicting updater, disable it or run with --fix-conflicts", slog.String("updater", conflict.Name), slog.String("reason", conflict.Description))
+			continue
+		}
+		err := conflict.Fix()
+		if err != nil {
+			slog.Error("Failed disabling conflicting updater", slog.String("updater", conflict.Name), slog.Any("error", err))
+		} else {
+			slog.Info("Disabled conflicting updater", slog.String("updater", conflict.Name), slog.String("reason", conflict.Description))
+		}
+	}
+
 	brewUpdater, err := drv.BrewUpdater{}.New(*initConfiguration)
 	brewUpdater.Config.Enabled = err == nil
 
@@ -75,18 +97,58 @@ func Update(cmd *cobra.Command, args []string) {
 	distroboxUpdater.Config.Enabled = err == nil
 	distroboxUpdater.SetUsers(users)
 
+	podmanUpdater, err := drv.PodmanUpdater{}.New(*initConfiguration)
+	podmanUpdater.Config.Enabled = err == nil
+	podmanUpdater.SetUsers(users)
+
+	nixUpdater, err := drv.NixUpdater{}.New(*initConfiguration)
+	nixUpdater.Config.Enabled = err == nil
+	nixUpdater.SetUsers(users)
+
+	toolchainUpdater, err := drv.ToolchainUpdater{}.New(*initConfiguration)
+	if err != nil {
+		slog.Error("Invalid toolchain configuration, disabling toolchain updates", slog.Any("error", err))
+	}
+	toolchainUpdater.Config.Enabled = err == nil
+	toolchainUpdater.SetUsers(users)
+
+	firmwareUpdater, err := drv.FirmwareUpdater{}.New(*initConfiguration)
+	if err != nil {
+		slog.Debug("Disabling firmware driver", slog.Any("error", err))
+		firmwareUpdater.Config.Enabled = false
+	}
+
+	// Split runs only take care of the system image
+	if phase != drv.PhaseAll {
+		brewUpdater.Config.Enabled = false
+		flatpakUpdater.Config.Enabled = false
+		distroboxUpdater.Config.Enabled = false
+		podmanUpdater.Config.Enabled = false
+		nixUpdater.Config.Enabled = false
+		toolchainUpdater.Config.Enabled = false
+		firmwareUpdater.Config.Enabled = false
+	}
+
 	var enableUpd bool = true
-	var systemOutdated bool
+
+	defer installRegistryConfig(initConfiguration.Environment)()
 
 	rpmOstreeUpdater, err := drv.RpmOstreeUpdater{}.New(*initConfiguration)
 	if err != nil {
+		slog.Error("Failed getting rpm-ostree driver", slog.Any("error", err))
 		enableUpd = false
 	}
 
 	systemUpdater, err := drv.SystemUpdater{}.New(*initConfiguration)
 	if err != nil {
+		slog.Error("Failed getting system driver", slog.Any("error", err))
 		enableUpd = false
 	}
+	systemUpdater.Rollout, err = drv.NewRolloutPolicy(initConfiguration.Environment)
+	if err != nil {
+		slog.Error("Invalid rollout policy, updating without a rollout delay", slog.Any("error", err))
+		systemUpdater.Rollout = drv.RolloutPolicy{}
+	}
 
 	isBootc, err := drv.BootcCompatible(systemUpdater.BinaryPath)
 	if err != nil {
@@ -100,21 +162,128 @@ func Update(cmd *cobra.Command, args []string) {
 	systemUpdater.Config.Enabled = enableUpd && isBootc
 	rpmOstreeUpdater.Config.Enabled = enableUpd && !isBootc
 
+	// Check uses the blocklist, so load it before systemUpdater gets copied into mainSystemDriver
+	systemUpdater.Blocklist, err = drv.LoadBlocklist(initConfiguration.Environment, true)
+	if err != nil {
+		slog.Error("Failed loading image blocklist", slog.Any("error", err))
+	}
+
 	var mainSystemDriver drv.SystemUpdateDriver = systemUpdater
 	if !systemUpdater.Config.Enabled {
 		mainSystemDriver = rpmOstreeUpdater
 	}
 
-	enableUpd, err = mainSystemDriver.Check()
+	outdatedPolicy, err := drv.NewOutdatedPolicy(initConfiguration.Environment)
+	if err != nil {
+		slog.Error("Invalid outdated image policy, using defaults", "error", err)
+		outdatedPolicy, _ = drv.NewOutdatedPolicy(nil)
+	}
+	outdatedReport, err := mainSystemDriver.Outdated(outdatedPolicy)
+	if err != nil {
+		slog.Error("Failed checking if system is out of date", slog.Any("error", err))
+	}
+	runReport.SystemChecked = err == nil
+	forceUpdate := outdatedReport.Level >= drv.OutdatedForce
+	if forceUpdate {
+		slog.Warn("The system image is too old, updating regardless of the rollout delay and hardware checks", slog.Int("age_days", outdatedReport.Booted.AgeDays))
+		// Check runs on the copy in mainSystemDriver, which has to be replaced
+		systemUpdater.Rollout = drv.RolloutPolicy{}
+		if systemUpdater.Config.Enabled {
+			mainSystemDriver = systemUpdater
+		}
+	}
+	if systemUpdater.Config.Enabled || rpmOstreeUpdater.Config.Enabled {
+		// Deployments staged by an earlier run, in case this one stops early
+		recordRebootPending(runReport, mainSystemDriver)
+	}
+	runReport.OutdatedLevel = outdatedReport.Level
+	if outdatedReport.Booted != nil {
+		runReport.BootedVersion = outdatedReport.Booted.Version
+		runReport.BootedBuildTime = outdatedReport.Booted.BuildTime
+	}
+
+	if hwCheck {
+		err := checks.RunHwChecks(systemUpdater.Offline())
+		if err != nil && !forceUpdate {
+			slog.Error("Hardware checks failed", "error", err)
+			runReport.Error = "Hardware checks failed: " + err.Error()
+			return
+		}
+		if err != nil {
+			slog.Warn("Hardware checks failed, updating anyway because the system image is too old", "error", err)
+		} else {
+			slog.Info("Hardware checks passed")
+		}
+	}
+
+	// Background downloads only run on unmetered networks, with or without the hardware checks
+	if phase == drv.PhaseDownload && !systemUpdater.Offline() && !forceUpdate {
+		metered, err := checks.Metered()
+		if err != nil {
+			slog.Debug("Failed checking if the network is metered", slog.Any("error", err))
+		}
+		if metered {
+			slog.Info("Network is metered, not downloading updates")
+			return
+		}
+	}
+
+	if phase == drv.PhaseApply {
+		// Applying must not depend on the network, only on what got downloaded before
+		var state *drv.PhaseState
+		state, err = drv.LoadPhaseState()
+		enableUpd = state != nil
+	} else {
+		enableUpd, err = mainSystemDriver.Check()
+	}
 	if err != nil {
-		slog.Error("Failed checking for updates")
+		slog.Error("Failed checking for updates", slog.Any("error", err))
+		// A registry that is down has to show up as a failed run
+		systemConfig := systemUpdater.Config
+		if !systemUpdater.Config.Enabled {
+			systemConfig = rpmOstreeUpdater.Config
+		}
+		tmpout := drv.CommandOutput{}.New(nil, err)
+		tmpout.Stderr = err
+		tmpout.SetFailureContext("System update check")
+		runReport.AddOutputs(drv.TagDriver([]drv.CommandOutput{*tmpout}, systemConfig))
+	}
+	if enableUpd {
+		runReport.PendingUpdates["system"] = 1
+	} else {
+		runReport.PendingUpdates["system"] = 0
+	}
+	if firmwareUpdater.Config.Enabled {
+		pending, err := firmwareUpdater.Check()
+		if err == nil {
+			runReport.PendingUpdates["firmware"] = len(*pending)
+		}
+	}
+
+	if !enableUpd && err == nil && systemUpdater.Config.Enabled && phase != drv.PhaseApply {
+		deferred, eligibleAt, err := systemUpdater.DeferredUpdate()
+		if err == nil && deferred != nil {
+			slog.Info("System update held back by staged rollout", slog.String("version", deferred.Version), slog.Time("eligible_at", eligibleAt))
+		}
+		blocked, err := systemUpdater.BlockedUpdate(systemUpdater.Blocklist)
+		if err == nil && blocked != nil {
+			slog.Warn("Not staging system update, it is on the blocklist", slog.String("version", blocked.Version), slog.String("digest", blocked.ImageDigest))
+		}
+		rejected, err := systemUpdater.RejectedUpdate()
+		if err == nil && rejected != nil {
+			slog.Warn("Not staging system update, it failed its boot health check before", slog.String("version", rejected.Version), slog.String("digest", rejected.ImageDigest))
+		}
 	}
 
 	if !enableUpd {
 		slog.Debug("No system update found, disabiling module")
+		if phase != drv.PhaseAll {
+			slog.Info("Nothing to " + phase)
+			return
+		}
 	}
 
-	totalSteps := brewUpdater.Steps() + flatpakUpdater.Steps() + distroboxUpdater.Steps()
+	totalSteps := brewUpdater.Steps() + flatpakUpdater.Steps() + distroboxUpdater.Steps() + podmanUpdater.Steps() + nixUpdater.Steps() + toolchainUpdater.Steps() + firmwareUpdater.Steps()
 	if enableUpd {
 		totalSteps += mainSystemDriver.Steps()
 	}
@@ -146,48 +315,112 @@ func Update(cmd *cobra.Command, args []string) {
 	}
 	flatpakUpdater.Tracker = trackerConfig
 	distroboxUpdater.Tracker = trackerConfig
+	podmanUpdater.Tracker = trackerConfig
+	nixUpdater.Tracker = trackerConfig
+	toolchainUpdater.Tracker = trackerConfig
 
 	var outputs = []drv.CommandOutput{}
+	var systemChangelog *drv.Changelog
 
-	systemOutdated, err = mainSystemDriver.Outdated()
+	outdatedWarning := outdatedReport.Message()
+	if outdatedWarning != "" {
+		notify := session.Notify
+		if outdatedReport.Booted.Level >= drv.OutdatedWarn {
+			notify = session.NotifyCritical
+		}
+		err := notify("System Warning", outdatedWarning)
+		if err != nil {
+			slog.Error("Failed showing warning notification")
+		}
+		slog.Warn(outdatedWarning, slog.Int("age_days", outdatedReport.Booted.AgeDays), slog.String("level", outdatedReport.Booted.Level.String()))
+	}
 
-	if err != nil {
-		slog.Error("Failed checking if system is out of date")
+	if systemUpdater.Config.Enabled {
+		status, err := systemUpdater.Status()
+		if err == nil && status.Status.Booted != nil && systemUpdater.Blocklist.Blocks(status.Status.Booted.Image) {
+			booted := status.Status.Booted.Image
+			slog.Error("The booted system image is on the blocklist, roll back or update as soon as possible", slog.String("version", booted.Version), slog.String("digest", booted.ImageDigest))
+			err := session.NotifyCritical("System Warning", "The running system image is known to be broken. Roll back or update as soon as possible")
+			if err != nil {
+				slog.Error("Failed showing warning notification")
+			}
+		}
 	}
 
-	if systemOutdated {
-		const OUTDATED_WARNING = "There hasn't been an update in over a month. Consider rebooting or running updates manually"
-		err := session.Notify("System Warning", OUTDATED_WARNING)
-		if err != nil {
-			slog.Error("Failed showing warning notification")
+	if enableUpd && systemUpdater.Config.Enabled {
+		current, target, err := systemUpdater.SwitchTarget()
+		if err == nil && target != "" {
+			slog.Info("Switching system image", slog.String("from", current), slog.String("to", target))
 		}
-		slog.Warn(OUTDATED_WARNING)
 	}
 
 	if enableUpd {
 		percent.ChangeTrackerMessageFancy(pw, tracker, progressEnabled, percent.TrackerMessage{Title: systemUpdater.Config.Title, Description: systemUpdater.Config.Description})
 		var out *[]drv.CommandOutput
-		out, err = mainSystemDriver.Update()
-		outputs = append(outputs, *out...)
+		switch phase {
+		case drv.PhaseDownload:
+			out, err = mainSystemDriver.Download()
+		case drv.PhaseApply:
+			out, err = mainSystemDriver.Apply()
+		default:
+			out, err = mainSystemDriver.Update()
+		}
+		systemConfig := systemUpdater.Config
+		if !systemUpdater.Config.Enabled {
+			systemConfig = rpmOstreeUpdater.Config
+		}
+		outputs = append(outputs, drv.TagDriver(*out, systemConfig)...)
+		tracker.IncrementSection(err)
+
+		if err == nil && !dryRun && phase != drv.PhaseApply {
+			systemChangelog, err = mainSystemDriver.Changelog()
+			if err != nil {
+				slog.Debug("Failed comparing deployments", slog.Any("error", err))
+			}
+		}
+	}
+
+	if firmwareUpdater.Config.Enabled {
+		percent.ChangeTrackerMessageFancy(pw, tracker, progressEnabled, percent.TrackerMessage{Title: firmwareUpdater.Config.Title, Description: firmwareUpdater.Config.Description})
+		out, err := firmwareUpdater.Update()
+		outputs = append(outputs, drv.TagDriver(*out, firmwareUpdater.Config)...)
 		tracker.IncrementSection(err)
 	}
 
 	if brewUpdater.Config.Enabled {
 		percent.ChangeTrackerMessageFancy(pw, tracker, progressEnabled, percent.TrackerMessage{Title: brewUpdater.Config.Title, Description: brewUpdater.Config.Description})
 		out, err := brewUpdater.Update()
-		outputs = append(outputs, *out...)
+		outputs = append(outputs, drv.TagDriver(*out, brewUpdater.Config)...)
+		tracker.IncrementSection(err)
+	}
+
+	if nixUpdater.Config.Enabled {
+		out, err := nixUpdater.Update()
+		outputs = append(outputs, drv.TagDriver(*out, nixUpdater.Config)...)
+		tracker.IncrementSection(err)
+	}
+
+	if toolchainUpdater.Config.Enabled {
+		out, err := toolchainUpdater.Update()
+		outputs = append(outputs, drv.TagDriver(*out, toolchainUpdater.Config)...)
 		tracker.IncrementSection(err)
 	}
 
 	if flatpakUpdater.Config.Enabled {
 		out, err := flatpakUpdater.Update()
-		outputs = append(outputs, *out...)
+		outputs = append(outputs, drv.TagDriver(*out, flatpakUpdater.Config)...)
 		tracker.IncrementSection(err)
 	}
 
 	if distroboxUpdater.Config.Enabled {
 		out, err := distroboxUpdater.Update()
-		outputs = append(outputs, *out...)
+		outputs = append(outputs, drv.TagDriver(*out, distroboxUpdater.Config)...)
+		tracker.IncrementSection(err)
+	}
+
+	if podmanUpdater.Config.Enabled {
+		out, err := podmanUpdater.Update()
+		outputs = append(outputs, drv.TagDriver(*out, podmanUpdater.Config)...)
 		tracker.IncrementSection(err)
 	}
 
@@ -195,11 +428,32 @@ func Update(cmd *cobra.Command, args []string) {
 		pw.Stop()
 		percent.ResetOscProgress()
 	}
+	runReport.AddOutputs(outputs)
+	if systemUpdater.Config.Enabled || rpmOstreeUpdater.Config.Enabled {
+		recordRebootPending(runReport, mainSystemDriver)
+	}
+	if systemChangelog != nil {
+		summary := systemChangelog.Summary()
+		runReport.Changelog = summary
+		slog.Info("System update summary", slog.String("changes", summary))
+		// Downloads aren't deployed yet, there is nothing to reboot into
+		if phase != drv.PhaseDownload {
+			err := session.Notify("System Updated", summary+". Reboot to apply the update")
+			if err != nil {
+				slog.Error("Failed showing update notification")
+			}
+		}
+	}
+
+	if runReport.RebootRequired {
+		slog.Info("Reboot pending, some updates will be applied on the next boot")
+	}
+
 	if verboseRun {
 		slog.Info("Verbose run requested")
 
 		for _, output := range outputs {
-			slog.Info(output.Context, slog.String("stdout", output.Stdout), slog.Any("stderr", output.Stderr), slog.Any("cli", output.Cli))
+			slog.Info(output.Context, outputAttrs(output)...)
 		}
 
 		return
@@ -216,7 +470,7 @@ func Update(cmd *cobra.Command, args []string) {
 		slog.Warn("Exited with failed updates.")
 
 		for _, output := range failures {
-			slog.Info(output.Context, slog.String("stdout", output.Stdout), slog.Any("stderr", output.Stderr), slog.Any("cli", output.Cli))
+			slog.Info(output.Context, outputAttrs(output)...)
 		}
 
 		return
@@ -224,3 +478,14 @@ func Update(cmd *cobra.Command, args []string) {
 
 	slog.Info("Updates Completed Successfully")
 }
+
+func outputAttrs(output drv.CommandOutput) []any {
+	attrs := []any{slog.String("stdout", output.Stdout), slog.Any("stderr", output.Stderr), slog.Any("cli", output.Cli)}
+	if output.Driver != "" {
+		attrs = append(attrs, slog.String("driver", output.Driver))
+	}
+	if output.User != "" {
+		attrs = append(attrs, slog.String("user", output.User))
+	}
+	return attrs
+}
@@ -2,25 +2,116 @@ package cmd
 
 import (
 	"log/slog"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/ublue-os/uupd/drv"
+	"github.com/ublue-os/uupd/pkg/filelock"
 )
 
 func UpdateCheck(cmd *cobra.Command, args []string) {
-	systemUpdater, err := drv.SystemUpdater{}.New(drv.UpdaterInitConfiguration{})
+	initConfiguration := drv.UpdaterInitConfiguration{}.New()
+	systemUpdater, err := drv.SystemUpdater{}.New(*initConfiguration)
 	if err != nil {
 		slog.Error("Failed getting system driver", slog.Any("error", err))
 		return
 	}
-	updateAvailable, err := systemUpdater.Check()
+	systemUpdater.Rollout, err = drv.NewRolloutPolicy(initConfiguration.Environment)
+	if err != nil {
+		slog.Error("Invalid rollout policy, checking without a rollout delay", slog.Any("error", err))
+		systemUpdater.Rollout = drv.RolloutPolicy{}
+	}
+	current, target, err := systemUpdater.SwitchTarget()
+	if err != nil {
+		slog.Error("Failed checking for updates", slog.Any("error", err))
+		return
+	}
+	if target != "" {
+		slog.Info("Image switch pending", slog.String("from", current), slog.String("to", target))
+	}
+
+	blocklist, err := drv.LoadBlocklist(initConfiguration.Environment, true)
+	if err != nil {
+		slog.Error("Failed loading image blocklist", slog.Any("error", err))
+	}
+
+	// A running update installed the registry config already and removes it once it is done,
+	// only bootc's check needs it, so the lock is held as briefly as possible
+	lock, err := filelock.AcquireLock(0)
+	removeRegistryConfig := func() {}
+	if err == nil {
+		removeRegistryConfig = installRegistryConfig(initConfiguration.Environment)
+	} else {
+		slog.Debug("Not installing registry mirrors and credentials", slog.String("reason", err.Error()))
+	}
+	update, err := systemUpdater.AvailableUpdate()
+	removeRegistryConfig()
+	if lock != nil {
+		releaseLock(lock)
+	}
 	if err != nil {
 		slog.Error("Failed checking for updates", slog.Any("error", err))
 		return
 	}
-	if updateAvailable {
-		slog.Info("Update Available")
+	status, err := systemUpdater.Status()
+	if err != nil {
+		slog.Error("Failed getting bootc status", slog.Any("error", err))
+		return
+	}
+	var booted drv.ImageStatus
+	if status.Status.Booted != nil && status.Status.Booted.Image != nil {
+		booted = *status.Status.Booted.Image
+	}
+	if blocklist.Blocks(&booted) {
+		slog.Error("The booted system image is on the blocklist, roll back or update as soon as possible", slog.String("digest", booted.ImageDigest))
+	}
+	if update != nil {
+		slog.Info("Update Available",
+			slog.String("image", update.Image.Image),
+			slog.String("current_version", booted.Version),
+			slog.String("new_version", update.Version),
+			slog.String("current_digest", booted.ImageDigest),
+			slog.String("new_digest", update.ImageDigest),
+		)
+		if blocklist.Blocks(update) {
+			slog.Warn("Update available but blocked by the image blocklist")
+		}
+		health, err := drv.LoadHealthState()
+		if err == nil && health.IsBad(update.ImageDigest) {
+			slog.Warn("Update failed its boot health check before and won't be staged")
+		}
+		eligibleAt, err := systemUpdater.RolloutEligibleAt(update)
+		if err != nil {
+			slog.Error("Failed reading rollout state", slog.Any("error", err))
+		}
+		if time.Now().Before(eligibleAt) {
+			slog.Info("Update held back by staged rollout", slog.Time("eligible_at", eligibleAt))
+		} else if systemUpdater.Rollout.Wait() > 0 {
+			slog.Info("Update rolled out to this machine", slog.Time("eligible_since", eligibleAt))
+		}
 	} else {
 		slog.Info("No updates available")
 	}
+
+	firmwareUpdater, err := drv.FirmwareUpdater{}.New(*initConfiguration)
+	if err != nil || !firmwareUpdater.Config.Enabled {
+		return
+	}
+	_, err = firmwareUpdater.RefreshMetadata()
+	if err != nil {
+		slog.Warn("Failed refreshing firmware metadata, using the cached one", slog.Any("error", err))
+	}
+	firmwareUpdates, err := firmwareUpdater.PendingUpdates()
+	if err != nil {
+		slog.Error("Failed checking for firmware updates", slog.Any("error", err))
+		return
+	}
+	for _, update := range firmwareUpdates {
+		slog.Info("Firmware Update Available",
+			slog.String("device", update.Device),
+			slog.String("current", update.CurrentVersion),
+			slog.String("version", update.Version),
+			slog.Bool("reboot_required", update.NeedsReboot),
+		)
+	}
 }
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"log/slog"
+	"os/exec"
+
+	"github.com/spf13/cobra"
+	"github.com/ublue-os/uupd/drv"
+	"github.com/ublue-os/uupd/pkg/session"
+)
+
+func VerifyBoot(cmd *cobra.Command, args []string) {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		slog.Error("Failed to get dry-run flag", "error", err)
+		return
+	}
+
+	initConfiguration := drv.UpdaterInitConfiguration{}.New()
+	healthCheck, err := drv.NewHealthCheck(initConfiguration.Environment)
+	if err != nil {
+		slog.Error("Invalid health check configuration", slog.Any("error", err))
+		return
+	}
+	systemUpdater, err := drv.SystemUpdater{}.New(*initConfiguration)
+	if err != nil {
+		slog.Error("Failed getting system driver", slog.Any("error", err))
+		return
+	}
+	status, err := systemUpdater.Status()
+	if err != nil {
+		slog.Error("Failed getting bootc status", slog.Any("error", err))
+		return
+	}
+	digest := status.Status.Booted.Digest()
+	if digest == "" {
+		slog.Info("Booted deployment isn't a container image, nothing to verify")
+		return
+	}
+
+	result := healthCheck.Run()
+	for _, output := range result.Outputs {
+		if output.Failure {
+			slog.Warn(output.Context+" failed", slog.String("stdout", output.Stdout), slog.Any("stderr", output.Stderr))
+		}
+	}
+
+	state, err := drv.LoadHealthState()
+	if err != nil {
+		slog.Error("Failed reading health state, starting over", slog.Any("error", err))
+		state = drv.HealthState{}
+	}
+	rollback := state.Record(digest, result.Healthy, healthCheck.MaxFailures)
+	defer func() {
+		err := drv.SaveHealthState(state)
+		if err != nil {
+			slog.Error("Failed saving health state", slog.Any("error", err))
+		}
+	}()
+
+	if result.Healthy {
+		slog.Info("Boot verified", slog.String("digest", digest))
+		return
+	}
+	slog.Warn("Boot health check failed", slog.String("digest", digest), slog.Int("failures", state.Failures), slog.Int("max_failures", healthCheck.MaxFailures))
+	if !rollback {
+		return
+	}
+	if status.Status.Rollback == nil {
+		slog.Error("Boot keeps failing its health check, but there is no deployment to roll back to")
+		return
+	}
+	if state.Failed(status.Status.Rollback.Digest()) {
+		slog.Error("Boot keeps failing its health check, but the rollback deployment failed it too, not rolling back", slog.String("rollback_digest", status.Status.Rollback.Digest()))
+		return
+	}
+	if dryRun {
+		slog.Info("Would roll back and reboot (dry run)", slog.String("to", status.Status.Rollback.Digest()))
+		return
+	}
+
+	lock := acquireLock(cmd)
+	if lock == nil {
+		return
+	}
+	defer releaseLock(lock)
+
+	state.MarkBad(digest)
+	outputs, err := systemUpdater.Rollback()
+	if err != nil {
+		slog.Error("Failed rolling back", slog.Any("error", err))
+		for _, output := range *outputs {
+			slog.Info(output.Context, slog.String("stdout", output.Stdout), slog.Any("stderr", output.Stderr), slog.Any("cli", output.Cli))
+		}
+		return
+	}
+	slog.Warn("Rolled back after repeated boot health check failures, rebooting", slog.String("bad_digest", digest), slog.String("to", status.Status.Rollback.Digest()))
+	err = session.NotifyCritical("System Rollback", "The system image failed its health check and was rolled back, rebooting")
+	if err != nil {
+		slog.Error("Failed showing rollback notification")
+	}
+	// The state has to be on disk before rebooting
+	err = drv.SaveHealthState(state)
+	if err != nil {
+		slog.Error("Failed saving health state", slog.Any("error", err))
+	}
+	err = exec.Command("systemctl", "reboot").Run()
+	if err != nil {
+		slog.Error("Failed rebooting", slog.Any("error", err))
+	}
+}
@@ -1,34 +1,68 @@
 package cmd
 
 import (
-	"log"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/ublue-os/uupd/drv"
 	"github.com/ublue-os/uupd/pkg/filelock"
 )
 
+// Lists everything that is in the middle of changing the system
+func updatesInProgress() []string {
+	var busy []string
+	owner, running, err := filelock.CurrentOwner()
+	if err != nil {
+		slog.Debug("Failed reading uupd lock", slog.Any("error", err))
+	}
+	if owner != nil {
+		busy = append(busy, owner.String())
+	} else if running {
+		busy = append(busy, "uupd")
+	}
+	if drv.SysrootLocked() {
+		busy = append(busy, "ostree sysroot lock (bootc/rpm-ostree)")
+	}
+	transaction, err := drv.RpmOstreeTransaction()
+	if err != nil {
+		slog.Debug("Failed getting rpm-ostree transaction", slog.Any("error", err))
+	}
+	if transaction != "" {
+		busy = append(busy, "rpm-ostree "+transaction+" transaction")
+	}
+	return busy
+}
+
 func Wait(cmd *cobra.Command, args []string) {
-	// TODO: rely on bootc to do transaction wait
-	lockFilePath := "/sysroot/ostree/lock"
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		slog.Error("Failed to get timeout flag", "error", err)
+		return
+	}
 
+	startTime := time.Now()
+	lastReport := startTime
+	var waitingOn string
 	for {
-
-		time.Sleep(2 * time.Second)
-		file, err := os.Open(lockFilePath)
-		if err != nil {
-			// file must not exist
+		busy := updatesInProgress()
+		if len(busy) == 0 {
 			break
 		}
-
-		if filelock.IsFileLocked(file) {
-			file.Close()
-			log.Printf("Waiting for lockfile: %s", lockFilePath)
-		} else {
-			file.Close()
-			break
+		waited := time.Since(startTime).Round(time.Second)
+		if timeout > 0 && waited >= timeout {
+			slog.Error("Timed out waiting", slog.String("on", strings.Join(busy, ", ")), slog.String("waited", waited.String()))
+			os.Exit(1)
+		}
+		// Report whenever something else is holding things up, and every 30 seconds otherwise
+		if strings.Join(busy, ", ") != waitingOn || time.Since(lastReport) >= 30*time.Second {
+			waitingOn = strings.Join(busy, ", ")
+			lastReport = time.Now()
+			slog.Info("Waiting", slog.String("on", waitingOn), slog.String("waited", waited.String()))
 		}
+		time.Sleep(2 * time.Second)
 	}
-	log.Printf("Done Waiting!")
+	slog.Info("Done waiting", slog.String("waited", time.Since(startTime).Round(time.Second).String()))
 }
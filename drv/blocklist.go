@@ -0,0 +1,110 @@
+package drv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Last copy of UUPD_BLOCKLIST_URL, used when it can't be fetched
+const remoteBlocklistCache = "/var/lib/uupd/blocklist.remote"
+
+// Image digests or versions that must never be deployed, one per line, # starts a comment
+type Blocklist struct {
+	Entries []string
+}
+
+func parseBlocklist(content []byte) []string {
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+func fetchBlocklist(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching blocklist from %s: %s", url, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// Reads the local blocklist from UUPD_BLOCKLIST_FILE (default /etc/uupd/blocklist) and the cached
+// copy of UUPD_BLOCKLIST_URL, refreshing the cache first if sync is set.
+// A failed refresh is returned along with the blocklist, which then uses the stale copy.
+func LoadBlocklist(env EnvironmentMap, sync bool) (Blocklist, error) {
+	var blocklist Blocklist
+	var syncErr error
+
+	path, exists := env["UUPD_BLOCKLIST_FILE"]
+	if !exists || path == "" {
+		path = "/etc/uupd/blocklist"
+	}
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return blocklist, err
+	}
+	blocklist.Entries = parseBlocklist(content)
+
+	url := env["UUPD_BLOCKLIST_URL"]
+	if url == "" {
+		return blocklist, nil
+	}
+	if sync {
+		remote, err := fetchBlocklist(url)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(remoteBlocklistCache), 0755)
+		}
+		if err == nil {
+			err = os.WriteFile(remoteBlocklistCache, remote, 0644)
+		}
+		syncErr = err
+	}
+	remote, err := os.ReadFile(remoteBlocklistCache)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return blocklist, err
+	}
+	blocklist.Entries = append(blocklist.Entries, parseBlocklist(remote)...)
+	return blocklist, syncErr
+}
+
+// Whether the image's digest or version is listed
+func (blocklist Blocklist) Blocks(image *ImageStatus) bool {
+	if image == nil {
+		return false
+	}
+	return (image.ImageDigest != "" && slices.Contains(blocklist.Entries, image.ImageDigest)) ||
+		(image.Version != "" && slices.Contains(blocklist.Entries, image.Version))
+}
+
+// The update bootc found during the last check if the blocklist holds it back
+func (dr SystemUpdater) BlockedUpdate(blocklist Blocklist) (*ImageStatus, error) {
+	status, err := dr.Status()
+	if err != nil {
+		return nil, err
+	}
+	update := status.AvailableUpdate()
+	if !blocklist.Blocks(update) {
+		return nil, nil
+	}
+	return update, nil
+}
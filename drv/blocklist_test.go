@@ -0,0 +1,62 @@
+package drv
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	content := "# known bad builds\nsha256:abc\n\n  41.20250101  # broke audio\n#sha256:def\n"
+	want := []string{"sha256:abc", "41.20250101"}
+	if got := parseBlocklist([]byte(content)); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBlocklistBlocks(t *testing.T) {
+	blocklist := Blocklist{Entries: []string{"sha256:abc", "41.20250101"}}
+	tests := []struct {
+		name  string
+		image *ImageStatus
+		want  bool
+	}{
+		{"no image", nil, false},
+		{"blocked digest", &ImageStatus{ImageDigest: "sha256:abc", Version: "41.20250202"}, true},
+		{"blocked version", &ImageStatus{ImageDigest: "sha256:123", Version: "41.20250101"}, true},
+		{"other image", &ImageStatus{ImageDigest: "sha256:123", Version: "41.20250202"}, false},
+		{"digest prefix", &ImageStatus{ImageDigest: "sha256:abcd"}, false},
+		{"no digest or version", &ImageStatus{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := blocklist.Blocks(test.image); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+	if (Blocklist{}).Blocks(&ImageStatus{}) {
+		t.Error("empty blocklist blocks an image without digest")
+	}
+}
+
+func TestLoadBlocklistFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist")
+	err := os.WriteFile(path, []byte("sha256:abc\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocklist, err := LoadBlocklist(EnvironmentMap{"UUPD_BLOCKLIST_FILE": path}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(blocklist.Entries, []string{"sha256:abc"}) {
+		t.Errorf("got %q", blocklist.Entries)
+	}
+
+	blocklist, err = LoadBlocklist(EnvironmentMap{"UUPD_BLOCKLIST_FILE": path + ".missing"}, false)
+	if err != nil || len(blocklist.Entries) != 0 {
+		t.Errorf("missing file: got %q, %v", blocklist.Entries, err)
+	}
+}
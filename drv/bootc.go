@@ -0,0 +1,172 @@
+package drv
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Typed model of `bootc status --format=json` (org.containers.bootc/v1 BootcHost)
+
+// Signature verification of an image, either "insecure", "containerPolicy" or {"ostreeRemote": "<remote>"}
+type ImageSignature struct {
+	Kind         string
+	OstreeRemote string
+}
+
+func (sig *ImageSignature) UnmarshalJSON(data []byte) error {
+	var kind string
+	if json.Unmarshal(data, &kind) == nil {
+		sig.Kind = kind
+		return nil
+	}
+	var remote struct {
+		OstreeRemote string `json:"ostreeRemote"`
+	}
+	err := json.Unmarshal(data, &remote)
+	if err != nil {
+		return fmt.Errorf("unknown image signature: %s", string(data))
+	}
+	sig.Kind = "ostreeRemote"
+	sig.OstreeRemote = remote.OstreeRemote
+	return nil
+}
+
+func (sig ImageSignature) String() string {
+	if sig.Kind == "ostreeRemote" {
+		return "ostreeRemote:" + sig.OstreeRemote
+	}
+	return sig.Kind
+}
+
+type ImageReference struct {
+	Image     string          `json:"image"`
+	Transport string          `json:"transport"`
+	Signature *ImageSignature `json:"signature"`
+}
+
+// Transports that don't need the network
+var localTransports = []string{"oci-archive", "oci", "dir", "containers-storage"}
+
+// Parses references written like String() does, "docker://" is accepted for registries
+func ParseImageReference(value string) ImageReference {
+	for _, transport := range localTransports {
+		if strings.HasPrefix(value, transport+":") {
+			return ImageReference{Image: strings.TrimPrefix(value, transport+":"), Transport: transport}
+		}
+	}
+	value = strings.TrimPrefix(value, "registry:")
+	value = strings.TrimPrefix(value, "docker://")
+	return ImageReference{Image: value, Transport: "registry"}
+}
+
+// Registry images are written as-is, others as <transport>:<path>
+func (ref ImageReference) String() string {
+	if ref.Transport == "" || ref.Transport == "registry" {
+		return ref.Image
+	}
+	return ref.Transport + ":" + ref.Image
+}
+
+func (ref ImageReference) Local() bool {
+	return slices.Contains(localTransports, ref.Transport)
+}
+
+type ImageStatus struct {
+	Image        ImageReference `json:"image"`
+	Version      string         `json:"version"`
+	Timestamp    string         `json:"timestamp"`
+	ImageDigest  string         `json:"imageDigest"`
+	Architecture string         `json:"architecture"`
+}
+
+// Build time of the image, zero if bootc didn't report it
+func (image ImageStatus) BuildTime() (time.Time, error) {
+	if image.Timestamp == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, image.Timestamp)
+}
+
+type OstreeDeployment struct {
+	Checksum     string `json:"checksum"`
+	DeploySerial int    `json:"deploySerial"`
+	Stateroot    string `json:"stateroot"`
+}
+
+type BootEntry struct {
+	Image *ImageStatus `json:"image"`
+	// Filled in by `bootc upgrade --check`
+	CachedUpdate *ImageStatus      `json:"cachedUpdate"`
+	Incompatible bool              `json:"incompatible"`
+	Pinned       bool              `json:"pinned"`
+	Store        string            `json:"store"`
+	Ostree       *OstreeDeployment `json:"ostree"`
+}
+
+// Digest of the deployed image, empty for entries without a container image
+func (entry *BootEntry) Digest() string {
+	if entry == nil || entry.Image == nil {
+		return ""
+	}
+	return entry.Image.ImageDigest
+}
+
+type BootcHost struct {
+	ApiVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Image     *ImageReference `json:"image"`
+		BootOrder string          `json:"bootOrder"`
+	} `json:"spec"`
+	Status struct {
+		Staged         *BootEntry `json:"staged"`
+		Booted         *BootEntry `json:"booted"`
+		Rollback       *BootEntry `json:"rollback"`
+		RollbackQueued bool       `json:"rollbackQueued"`
+		Type           string     `json:"type"`
+	} `json:"status"`
+}
+
+// Image the host is configured to track
+func (host BootcHost) SpecImage() string {
+	if host.Spec.Image == nil {
+		return ""
+	}
+	return host.Spec.Image.String()
+}
+
+func (host BootcHost) Incompatible() bool {
+	return (host.Status.Booted != nil && host.Status.Booted.Incompatible) ||
+		(host.Status.Staged != nil && host.Status.Staged.Incompatible)
+}
+
+// Returns the update found by the last `bootc upgrade --check`, nil if the booted
+// or staged deployment already has that digest
+func (host BootcHost) AvailableUpdate() *ImageStatus {
+	for _, entry := range []*BootEntry{host.Status.Staged, host.Status.Booted} {
+		if entry == nil || entry.CachedUpdate == nil || entry.CachedUpdate.ImageDigest == "" {
+			continue
+		}
+		digest := entry.CachedUpdate.ImageDigest
+		if digest == host.Status.Booted.Digest() || digest == host.Status.Staged.Digest() {
+			continue
+		}
+		return entry.CachedUpdate
+	}
+	return nil
+}
+
+func BootcStatus(binaryPath string) (BootcHost, error) {
+	var host BootcHost
+	cmd := exec.Command(binaryPath, "status", "--format=json")
+	out, err := cmd.Output()
+	if err != nil {
+		return host, err
+	}
+	err = json.Unmarshal(out, &host)
+	return host, err
+}
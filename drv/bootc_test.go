@@ -0,0 +1,100 @@
+package drv
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// Trimmed down `bootc status --format=json` output
+const bootcStatusFixture = `{
+  "apiVersion": "org.containers.bootc/v1",
+  "kind": "BootcHost",
+  "spec": {"image": {"image": "ghcr.io/ublue-os/bluefin:stable", "transport": "registry"}, "bootOrder": "default"},
+  "status": {
+    "staged": %s,
+    "booted": %s,
+    "rollback": null,
+    "rollbackQueued": false,
+    "type": "bootcHost"
+  }
+}`
+
+// A deployment of digest, with bootc's cached update if cached isn't empty
+func bootEntryFixture(digest string, cached string) string {
+	if digest == "" {
+		return "null"
+	}
+	image := func(digest string) string {
+		return `{"image": {"image": "ghcr.io/ublue-os/bluefin:stable", "transport": "registry"}, "version": "41", "timestamp": "2025-01-01T00:00:00Z", "imageDigest": "` + digest + `", "architecture": "amd64"}`
+	}
+	cachedUpdate := "null"
+	if cached != "" {
+		cachedUpdate = image(cached)
+	}
+	return `{"image": ` + image(digest) + `, "cachedUpdate": ` + cachedUpdate + `, "incompatible": false, "pinned": false, "store": "ostreeContainer", "ostree": {"checksum": "abc", "deploySerial": 0, "stateroot": "default"}}`
+}
+
+func parseBootcStatus(t *testing.T, staged string, booted string) BootcHost {
+	t.Helper()
+	var host BootcHost
+	content := []byte(fmt.Sprintf(bootcStatusFixture, staged, booted))
+	err := json.Unmarshal(content, &host)
+	if err != nil {
+		t.Fatalf("%v in %s", err, content)
+	}
+	return host
+}
+
+func TestAvailableUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		staged string
+		booted string
+		want   string
+	}{
+		{"no cached update", "null", bootEntryFixture("sha256:a", ""), ""},
+		{"cached equals booted", "null", bootEntryFixture("sha256:a", "sha256:a"), ""},
+		{"newer image, nothing staged", "null", bootEntryFixture("sha256:a", "sha256:b"), "sha256:b"},
+		{"cached equals staged", bootEntryFixture("sha256:b", ""), bootEntryFixture("sha256:a", "sha256:b"), ""},
+		{"cached on the staged deployment", bootEntryFixture("sha256:b", "sha256:b"), bootEntryFixture("sha256:a", ""), ""},
+		{"newer than staged", bootEntryFixture("sha256:b", "sha256:c"), bootEntryFixture("sha256:a", "sha256:b"), "sha256:c"},
+		{"booted without an image", "null", `{"image": null, "cachedUpdate": null, "incompatible": false, "pinned": false}`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			host := parseBootcStatus(t, test.staged, test.booted)
+			update := host.AvailableUpdate()
+			got := ""
+			if update != nil {
+				got = update.ImageDigest
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBootcHost(t *testing.T) {
+	host := parseBootcStatus(t, "null", bootEntryFixture("sha256:a", "sha256:b"))
+	if got := host.SpecImage(); got != "ghcr.io/ublue-os/bluefin:stable" {
+		t.Errorf("SpecImage() = %s", got)
+	}
+	if got := host.Status.Booted.Digest(); got != "sha256:a" {
+		t.Errorf("booted digest %s", got)
+	}
+	if got := host.Status.Staged.Digest(); got != "" {
+		t.Errorf("missing staged deployment has digest %s", got)
+	}
+	if host.Incompatible() {
+		t.Error("compatible host reported incompatible")
+	}
+	buildTime, err := host.Status.Booted.Image.BuildTime()
+	if err != nil || buildTime.Format("2006-01-02") != "2025-01-01" {
+		t.Errorf("BuildTime() = %v, %v", buildTime, err)
+	}
+	if got := (BootcHost{}).SpecImage(); got != "" {
+		t.Errorf("SpecImage() without a spec = %s", got)
+	}
+}
@@ -0,0 +1,117 @@
+package drv
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+)
+
+type Deployment struct {
+	Image     string `json:"image,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Digest    string `json:"digest,omitempty"`
+	// ostree commit, only known for deployments that exist on disk
+	Checksum string `json:"checksum,omitempty"`
+}
+
+type PackageChange struct {
+	Name       string `json:"name"`
+	Arch       string `json:"arch"`
+	OldVersion string `json:"old_version,omitempty"`
+	NewVersion string `json:"new_version,omitempty"`
+}
+
+type Changelog struct {
+	From Deployment `json:"from"`
+	To   Deployment `json:"to"`
+	// Packages can only be compared once the new deployment is downloaded
+	PackagesCompared bool            `json:"packages_compared"`
+	Added            []PackageChange `json:"added"`
+	Removed          []PackageChange `json:"removed"`
+	Upgraded         []PackageChange `json:"upgraded"`
+	Downgraded       []PackageChange `json:"downgraded"`
+}
+
+func (changelog Changelog) Summary() string {
+	var summary string
+	if changelog.From.Version != "" || changelog.To.Version != "" {
+		summary = fmt.Sprintf("%s -> %s", changelog.From.Version, changelog.To.Version)
+	} else {
+		summary = fmt.Sprintf("%s -> %s", changelog.From.Digest, changelog.To.Digest)
+	}
+	if !changelog.PackagesCompared {
+		return summary
+	}
+	return fmt.Sprintf("%s: %d upgraded, %d downgraded, %d added, %d removed", summary, len(changelog.Upgraded), len(changelog.Downgraded), len(changelog.Added), len(changelog.Removed))
+}
+
+// What `rpm-ostree db diff --format=json` prints, pkgdiff entries are [name, type, details] tuples
+type rpmOstreeDbDiff struct {
+	PkgDiff []json.RawMessage `json:"pkgdiff"`
+}
+
+// Packages in the details of a pkgdiff entry are [name, evr, arch]
+type rpmOstreePackageDiff struct {
+	PreviousPackage []string `json:"PreviousPackage"`
+	NewPackage      []string `json:"NewPackage"`
+}
+
+// pkgdiff types, see GetCachedUpdateRpmDiff in rpm-ostree's D-Bus API
+const rpmOstreeDiffDowngraded = 2
+
+// Sorts the output of `rpm-ostree db diff --format=json` into the changelog
+func (changelog *Changelog) parsePackageDiff(content []byte) error {
+	var diff rpmOstreeDbDiff
+	err := json.Unmarshal(content, &diff)
+	if err != nil {
+		return err
+	}
+
+	changelog.Added = []PackageChange{}
+	changelog.Removed = []PackageChange{}
+	changelog.Upgraded = []PackageChange{}
+	changelog.Downgraded = []PackageChange{}
+	for _, entry := range diff.PkgDiff {
+		var diffType int
+		var details rpmOstreePackageDiff
+		tuple := []any{new(string), &diffType, &details}
+		err = json.Unmarshal(entry, &tuple)
+		if err != nil {
+			return err
+		}
+		previous, updated := details.PreviousPackage, details.NewPackage
+		if len(previous) != 3 && len(updated) != 3 {
+			return fmt.Errorf("Unexpected package diff entry: %s", entry)
+		}
+		switch {
+		case len(previous) != 3:
+			changelog.Added = append(changelog.Added, PackageChange{Name: updated[0], Arch: updated[2], NewVersion: updated[1]})
+		case len(updated) != 3:
+			changelog.Removed = append(changelog.Removed, PackageChange{Name: previous[0], Arch: previous[2], OldVersion: previous[1]})
+		case diffType == rpmOstreeDiffDowngraded:
+			changelog.Downgraded = append(changelog.Downgraded, PackageChange{Name: updated[0], Arch: updated[2], OldVersion: previous[1], NewVersion: updated[1]})
+		default:
+			changelog.Upgraded = append(changelog.Upgraded, PackageChange{Name: updated[0], Arch: updated[2], OldVersion: previous[1], NewVersion: updated[1]})
+		}
+	}
+	for _, changes := range [][]PackageChange{changelog.Added, changelog.Removed, changelog.Upgraded, changelog.Downgraded} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	}
+	changelog.PackagesCompared = true
+	return nil
+}
+
+// Fills in the package changes between both deployments
+func (changelog *Changelog) comparePackages(rpmOstreePath string) error {
+	if changelog.From.Checksum == "" || changelog.To.Checksum == "" {
+		return nil
+	}
+	cmd := exec.Command(rpmOstreePath, "db", "diff", "--format=json", changelog.From.Checksum, changelog.To.Checksum)
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+	return changelog.parsePackageDiff(out)
+}
@@ -0,0 +1,90 @@
+package drv
+
+import (
+	"testing"
+)
+
+// Trimmed output of `rpm-ostree db diff --format=json`
+const dbDiffJSON = `{
+  "ostree-commit-from": "aaa",
+  "ostree-commit-to": "bbb",
+  "pkgdiff": [
+    ["kernel", 1, {"PreviousPackage": ["kernel", "6.11.3-300.fc41", "x86_64"], "NewPackage": ["kernel", "6.11.4-301.fc41", "x86_64"]}],
+    ["mesa-dri-drivers", 2, {"PreviousPackage": ["mesa-dri-drivers", "24.2.5-1.fc41", "x86_64"], "NewPackage": ["mesa-dri-drivers", "24.2.4-1.fc41", "x86_64"]}],
+    ["htop", 3, {"PreviousPackage": ["htop", "3.3.0-4.fc41", "x86_64"]}],
+    ["fastfetch", 4, {"NewPackage": ["fastfetch", "2.27.1-1.fc41", "x86_64"]}],
+    ["NetworkManager", 1, {"PreviousPackage": ["NetworkManager", "1:1.48.10-1.fc41", "x86_64"], "NewPackage": ["NetworkManager", "1:1.48.12-1.fc41", "x86_64"]}]
+  ]
+}`
+
+func TestParsePackageDiff(t *testing.T) {
+	var changelog Changelog
+	err := changelog.parsePackageDiff([]byte(dbDiffJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changelog.PackagesCompared {
+		t.Error("PackagesCompared should be set")
+	}
+
+	tests := []struct {
+		kind    string
+		changes []PackageChange
+		want    []PackageChange
+	}{
+		{"upgraded", changelog.Upgraded, []PackageChange{
+			{Name: "NetworkManager", Arch: "x86_64", OldVersion: "1:1.48.10-1.fc41", NewVersion: "1:1.48.12-1.fc41"},
+			{Name: "kernel", Arch: "x86_64", OldVersion: "6.11.3-300.fc41", NewVersion: "6.11.4-301.fc41"},
+		}},
+		{"downgraded", changelog.Downgraded, []PackageChange{
+			{Name: "mesa-dri-drivers", Arch: "x86_64", OldVersion: "24.2.5-1.fc41", NewVersion: "24.2.4-1.fc41"},
+		}},
+		{"removed", changelog.Removed, []PackageChange{
+			{Name: "htop", Arch: "x86_64", OldVersion: "3.3.0-4.fc41"},
+		}},
+		{"added", changelog.Added, []PackageChange{
+			{Name: "fastfetch", Arch: "x86_64", NewVersion: "2.27.1-1.fc41"},
+		}},
+	}
+	for _, test := range tests {
+		if len(test.changes) != len(test.want) {
+			t.Errorf("%s = %+v, want %+v", test.kind, test.changes, test.want)
+			continue
+		}
+		for i := range test.want {
+			if test.changes[i] != test.want[i] {
+				t.Errorf("%s[%d] = %+v, want %+v", test.kind, i, test.changes[i], test.want[i])
+			}
+		}
+	}
+
+	want := "41.1 -> 41.2: 2 upgraded, 1 downgraded, 1 added, 1 removed"
+	changelog.From.Version, changelog.To.Version = "41.1", "41.2"
+	if got := changelog.Summary(); got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
+
+func TestParsePackageDiffErrors(t *testing.T) {
+	for _, content := range []string{
+		`not json`,
+		`{"pkgdiff": [["broken", 1, {}]]}`,
+		`{"pkgdiff": [["broken", "one", {"NewPackage": ["broken", "1-1", "noarch"]}]]}`,
+	} {
+		var changelog Changelog
+		if err := changelog.parsePackageDiff([]byte(content)); err == nil {
+			t.Errorf("parsePackageDiff(%s) should fail", content)
+		}
+	}
+}
+
+func TestParsePackageDiffEmpty(t *testing.T) {
+	var changelog Changelog
+	err := changelog.parsePackageDiff([]byte(`{"ostree-commit-from": "a", "ostree-commit-to": "a", "pkgdiff": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changelog.Added == nil || len(changelog.Added)+len(changelog.Removed)+len(changelog.Upgraded)+len(changelog.Downgraded) != 0 {
+		t.Errorf("expected empty, non-nil changes, got %+v", changelog)
+	}
+}
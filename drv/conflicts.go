@@ -0,0 +1,180 @@
+package drv
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ublue-os/uupd/pkg/session"
+)
+
+const rpmOstreedConfig = "/etc/rpm-ostreed.conf"
+
+var automaticUpdatePolicyRegex = regexp.MustCompile(`(?m)^[ \t]*AutomaticUpdatePolicy[ \t]*=[ \t]*(\S+)[ \t]*$`)
+
+var unattendedUpdatesRegex = regexp.MustCompile(`(?m)^[ \t]*UseUnattendedUpdates[ \t]*=[ \t]*true[ \t]*$`)
+
+// Timers that update the system or Flatpaks behind uupd's back
+var conflictingTimers = []string{
+	"bootc-fetch-apply-updates.timer",
+	"rpm-ostreed-automatic.timer",
+	"flatpak-system-update.timer",
+	"flatpak-automatic.timer",
+}
+
+// Enabled for every user with `systemctl --global`
+var conflictingUserTimers = []string{
+	"flatpak-user-update.timer",
+}
+
+// Another updater fighting uupd over the sysroot or the Flatpak installations
+type Conflict struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	fix         func() error
+}
+
+// Turns the other updater off
+func (conflict Conflict) Fix() error {
+	return conflict.fix()
+}
+
+func newConflict(name string, description string, fix func() error) Conflict {
+	return Conflict{Name: name, Description: description, fix: fix}
+}
+
+func unitEnabled(unit string, global bool) bool {
+	cli := []string{"systemctl", "is-enabled", unit}
+	if global {
+		cli = []string{"systemctl", "--global", "is-enabled", unit}
+	}
+	out, _ := exec.Command(cli[0], cli[1:]...).Output()
+	return strings.TrimSpace(string(out)) == "enabled"
+}
+
+func disableUnit(unit string, global bool) func() error {
+	return func() error {
+		cli := []string{"systemctl", "disable", "--now", unit}
+		if global {
+			cli = []string{"systemctl", "--global", "disable", unit}
+		}
+		return exec.Command(cli[0], cli[1:]...).Run()
+	}
+}
+
+// rpm-ostreed stages updates on its own with AutomaticUpdatePolicy=stage
+func rpmOstreedConflict() *Conflict {
+	content, err := os.ReadFile(rpmOstreedConfig)
+	if err != nil {
+		return nil
+	}
+	match := automaticUpdatePolicyRegex.FindSubmatch(content)
+	if match == nil || string(match[1]) != "stage" {
+		return nil
+	}
+	conflict := newConflict("rpm-ostreed", "rpm-ostreed stages updates itself (AutomaticUpdatePolicy=stage in "+rpmOstreedConfig+")", func() error {
+		info, err := os.Stat(rpmOstreedConfig)
+		if err != nil {
+			return err
+		}
+		fixed := automaticUpdatePolicyRegex.ReplaceAll(content, []byte("AutomaticUpdatePolicy=none"))
+		err = os.WriteFile(rpmOstreedConfig, fixed, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		return exec.Command("rpm-ostree", "reload").Run()
+	})
+	return &conflict
+}
+
+// GNOME Software downloads and stages updates for users that leave download-updates on.
+// The fix locks the setting off system wide through dconf.
+func gnomeSoftwareConflicts(users []session.User) []Conflict {
+	var conflicts []Conflict
+	if _, err := os.Stat("/usr/bin/gnome-software"); err != nil {
+		return conflicts
+	}
+	for _, usr := range users {
+		out, err := session.RunUID(usr.UID, []string{"gsettings", "get", "org.gnome.software", "download-updates"}, nil)
+		if err != nil || strings.TrimSpace(string(out)) != "true" {
+			continue
+		}
+		conflicts = append(conflicts, newConflict("gnome-software", "GNOME Software downloads updates for User: "+usr.Name, lockGnomeSoftwareDownloads))
+	}
+	return conflicts
+}
+
+func lockGnomeSoftwareDownloads() error {
+	files := map[string]string{
+		"/etc/dconf/db/local.d/00-uupd":       "[org/gnome/software]\ndownload-updates=false\n",
+		"/etc/dconf/db/local.d/locks/00-uupd": "/org/gnome/software/download-updates\n",
+	}
+	for path, content := range files {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return exec.Command("dconf", "update").Run()
+}
+
+// Discover applies offline updates on its own with UseUnattendedUpdates=true,
+// set either system wide in /etc/xdg or by a user
+func discoverConflicts(users []session.User) []Conflict {
+	var conflicts []Conflict
+	if _, err := os.Stat("/usr/bin/plasma-discover"); err != nil {
+		return conflicts
+	}
+	configs := map[string]string{"/etc/xdg/PlasmaDiscoverUpdates": "system wide"}
+	for _, usr := range users {
+		info, err := user.LookupId(strconv.Itoa(usr.UID))
+		if err != nil {
+			continue
+		}
+		configs[filepath.Join(info.HomeDir, ".config", "PlasmaDiscoverUpdates")] = "for User: " + usr.Name
+	}
+	for path, scope := range configs {
+		content, err := os.ReadFile(path)
+		if err != nil || !unattendedUpdatesRegex.Match(content) {
+			continue
+		}
+		conflicts = append(conflicts, newConflict("discover", "Discover applies updates unattended "+scope, func() error {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			fixed := unattendedUpdatesRegex.ReplaceAll(content, []byte("UseUnattendedUpdates=false"))
+			return os.WriteFile(path, fixed, info.Mode().Perm())
+		}))
+	}
+	return conflicts
+}
+
+// Looks for other updaters that would race uupd, users being the ones logged in
+func DetectConflicts(users []session.User) []Conflict {
+	var conflicts []Conflict
+	if conflict := rpmOstreedConflict(); conflict != nil {
+		conflicts = append(conflicts, *conflict)
+	}
+	conflicts = append(conflicts, gnomeSoftwareConflicts(users)...)
+	conflicts = append(conflicts, discoverConflicts(users)...)
+	for _, timer := range conflictingTimers {
+		if unitEnabled(timer, false) {
+			conflicts = append(conflicts, newConflict(timer, timer+" is enabled", disableUnit(timer, false)))
+		}
+	}
+	for _, timer := range conflictingUserTimers {
+		if unitEnabled(timer, true) {
+			conflicts = append(conflicts, newConflict(timer, timer+" is enabled for all users", disableUnit(timer, true)))
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,51 @@
+package drv
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestConflictRegexesKeepSurroundingLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		regex       *regexp.Regexp
+		replacement string
+		content     string
+		want        string
+	}{
+		{
+			"rpm-ostreed policy after a blank line",
+			automaticUpdatePolicyRegex, "AutomaticUpdatePolicy=none",
+			"[Daemon]\n\nAutomaticUpdatePolicy=stage\n\nIdleExitTimeout=60\n",
+			"[Daemon]\n\nAutomaticUpdatePolicy=none\n\nIdleExitTimeout=60\n",
+		},
+		{
+			"rpm-ostreed policy with spaces",
+			automaticUpdatePolicyRegex, "AutomaticUpdatePolicy=none",
+			"[Daemon]\n  AutomaticUpdatePolicy = stage \n",
+			"[Daemon]\nAutomaticUpdatePolicy=none\n",
+		},
+		{
+			"discover unattended updates between blank lines",
+			unattendedUpdatesRegex, "UseUnattendedUpdates=false",
+			"[Global]\n\n\nUseUnattendedUpdates=true\n\n[Other]\n",
+			"[Global]\n\n\nUseUnattendedUpdates=false\n\n[Other]\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := string(test.regex.ReplaceAll([]byte(test.content), []byte(test.replacement)))
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	match := automaticUpdatePolicyRegex.FindSubmatch([]byte("[Daemon]\n\nAutomaticUpdatePolicy=stage\n"))
+	if match == nil || string(match[1]) != "stage" {
+		t.Errorf("policy not matched: %q", match)
+	}
+	if unattendedUpdatesRegex.Match([]byte("UseUnattendedUpdates=false\n")) {
+		t.Error("UseUnattendedUpdates=false shouldn't match")
+	}
+}
@@ -90,6 +90,7 @@ func (up *DistroboxUpdater) Update() (*[]CommandOutput, error) {
 		out, err := session.RunUID(user.UID, cli, nil)
 		tmpout = CommandOutput{}.New(out, err)
 		tmpout.Context = context
+		tmpout.User = user.Name
 		tmpout.Cli = cli
 		tmpout.Failure = err != nil
 		finalOutput = append(finalOutput, *tmpout)
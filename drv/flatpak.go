@@ -1,18 +1,19 @@
 package drv
 
 import (
-	"os/exec"
+	"strings"
 
 	"github.com/ublue-os/uupd/pkg/percent"
 	"github.com/ublue-os/uupd/pkg/session"
 )
 
 type FlatpakUpdater struct {
-	Config       DriverConfiguration
-	Tracker      *TrackerConfiguration
-	binaryPath   string
-	users        []session.User
-	usersEnabled bool
+	Config        DriverConfiguration
+	Tracker       *TrackerConfiguration
+	binaryPath    string
+	sideloadRepos []string
+	users         []session.User
+	usersEnabled  bool
 }
 
 func (up FlatpakUpdater) Steps() int {
@@ -47,6 +48,14 @@ func (up FlatpakUpdater) New(config UpdaterInitConfiguration) (FlatpakUpdater, e
 		up.binaryPath = binaryPath
 	}
 
+	// Local repos (e.g. USB drives created with `flatpak create-usb`) to pull from instead of the network
+	sideloadRepos, exists := up.Config.Environment["UUPD_FLATPAK_SIDELOAD_REPOS"]
+	if exists && sideloadRepos != "" {
+		for _, repo := range strings.Split(sideloadRepos, ",") {
+			up.sideloadRepos = append(up.sideloadRepos, strings.TrimSpace(repo))
+		}
+	}
+
 	return up, nil
 }
 
@@ -55,6 +64,14 @@ func (up *FlatpakUpdater) SetUsers(users []session.User) {
 	up.usersEnabled = true
 }
 
+func (up FlatpakUpdater) updateCli() []string {
+	cli := []string{up.binaryPath, "update", "-y"}
+	for _, repo := range up.sideloadRepos {
+		cli = append(cli, "--sideload-repo="+repo)
+	}
+	return cli
+}
+
 func (up FlatpakUpdater) Check() (*[]CommandOutput, error) {
 	return nil, nil
 }
@@ -75,9 +92,8 @@ func (up FlatpakUpdater) Update() (*[]CommandOutput, error) {
 	}
 
 	percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: up.Config.Description})
-	cli := []string{up.binaryPath, "update", "-y"}
-	flatpakCmd := exec.Command(cli[0], cli[1:]...)
-	out, err := flatpakCmd.CombinedOutput()
+	cli := up.updateCli()
+	out, err := session.RunScoped(cli)
 	tmpout := CommandOutput{}.New(out, err)
 	tmpout.Context = up.Config.Description
 	tmpout.Cli = cli
@@ -89,10 +105,11 @@ func (up FlatpakUpdater) Update() (*[]CommandOutput, error) {
 		up.Tracker.Tracker.IncrementSection(err)
 		context := *up.Config.UserDescription + " " + user.Name
 		percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: context})
-		cli := []string{up.binaryPath, "update", "-y"}
+		cli := up.updateCli()
 		out, err := session.RunUID(user.UID, cli, nil)
 		tmpout = CommandOutput{}.New(out, err)
 		tmpout.Context = context
+		tmpout.User = user.Name
 		tmpout.Cli = cli
 		tmpout.Failure = err != nil
 		finalOutput = append(finalOutput, *tmpout)
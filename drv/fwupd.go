@@ -0,0 +1,237 @@
+package drv
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/ublue-os/uupd/pkg/session"
+)
+
+// Device flags we care about, from libfwupd/fwupd-enums.h
+const (
+	fwupdDeviceFlagUpdatable     uint64 = 1 << 1
+	fwupdDeviceFlagOnlyOffline   uint64 = 1 << 2
+	fwupdDeviceFlagNeedsReboot   uint64 = 1 << 8
+	fwupdDeviceFlagNeedsShutdown uint64 = 1 << 17
+)
+
+const (
+	FirmwareModeOff     = "off"
+	FirmwareModeCheck   = "check"
+	FirmwareModeInstall = "install"
+)
+
+type FirmwareUpdate struct {
+	DeviceId       string
+	Device         string
+	CurrentVersion string
+	Version        string
+	NeedsReboot    bool
+}
+
+// Optional firmware driver backed by fwupd.
+// In "check" mode metadata is refreshed and pending updates are reported,
+// in "install" mode updates are installed and reboot-requiring updates are staged.
+type FirmwareUpdater struct {
+	Config     DriverConfiguration
+	Mode       string
+	binaryPath string
+	// Set once the metadata got refreshed, so that Check and Update don't both download it
+	refreshed bool
+}
+
+func (up FirmwareUpdater) Steps() int {
+	if up.Config.Enabled {
+		return 1
+	}
+	return 0
+}
+
+func (up FirmwareUpdater) New(config UpdaterInitConfiguration) (FirmwareUpdater, error) {
+	up.Config = DriverConfiguration{
+		Title:       "Firmware",
+		Description: "Device Firmware",
+		Enabled:     true,
+		MultiUser:   false,
+		DryRun:      config.DryRun,
+		Environment: config.Environment,
+	}
+
+	mode, exists := up.Config.Environment["UUPD_FIRMWARE"]
+	if !exists || mode == "" {
+		up.Mode = FirmwareModeOff
+	} else {
+		up.Mode = mode
+	}
+	switch up.Mode {
+	case FirmwareModeOff:
+		up.Config.Enabled = false
+	case FirmwareModeCheck, FirmwareModeInstall:
+	default:
+		up.Config.Enabled = false
+		return up, fmt.Errorf("Invalid firmware mode: %s", up.Mode)
+	}
+
+	binaryPath, exists := up.Config.Environment["UUPD_FWUPDMGR_BINARY"]
+	if !exists || binaryPath == "" {
+		up.binaryPath = "/usr/bin/fwupdmgr"
+	} else {
+		up.binaryPath = binaryPath
+	}
+
+	if up.Config.DryRun {
+		return up, nil
+	}
+
+	_, err := os.Stat(up.binaryPath)
+	if err != nil {
+		return up, err
+	}
+
+	return up, nil
+}
+
+// Lists updatable devices that have a newer release available, straight from the fwupd daemon
+func (up FirmwareUpdater) PendingUpdates() ([]FirmwareUpdate, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to system bus: %v", err)
+	}
+	defer conn.Close()
+
+	fwupd := conn.Object("org.freedesktop.fwupd", "/")
+
+	var devices []map[string]dbus.Variant
+	err = fwupd.Call("org.freedesktop.fwupd.GetDevices", 0).Store(&devices)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []FirmwareUpdate
+	for _, device := range devices {
+		flags, _ := device["Flags"].Value().(uint64)
+		if flags&fwupdDeviceFlagUpdatable == 0 {
+			continue
+		}
+		deviceId, ok := device["DeviceId"].Value().(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid DeviceId type, expected string")
+		}
+
+		var releases []map[string]dbus.Variant
+		err = fwupd.Call("org.freedesktop.fwupd.GetUpgrades", 0, deviceId).Store(&releases)
+		if err != nil {
+			// fwupd answers with an error (NothingToDo, NotSupported...) when there is nothing to install
+			if _, isDbusErr := err.(dbus.Error); isDbusErr {
+				continue
+			}
+			return nil, err
+		}
+		if len(releases) == 0 {
+			continue
+		}
+
+		name, _ := device["Name"].Value().(string)
+		currentVersion, _ := device["Version"].Value().(string)
+		// Releases are sorted newest first
+		version, _ := releases[0]["Version"].Value().(string)
+
+		updates = append(updates, FirmwareUpdate{
+			DeviceId:       deviceId,
+			Device:         name,
+			CurrentVersion: currentVersion,
+			Version:        version,
+			NeedsReboot:    flags&(fwupdDeviceFlagOnlyOffline|fwupdDeviceFlagNeedsReboot|fwupdDeviceFlagNeedsShutdown) != 0,
+		})
+	}
+	return updates, nil
+}
+
+// Downloads the latest metadata from the configured remotes, e.g. LVFS
+func (up *FirmwareUpdater) RefreshMetadata() (*CommandOutput, error) {
+	cli := []string{up.binaryPath, "refresh", "--force"}
+	out, err := session.RunScoped(cli)
+	tmpout := CommandOutput{}.New(out, err)
+	tmpout.Context = "Firmware metadata refresh"
+	tmpout.Cli = cli
+	tmpout.Failure = err != nil
+	up.refreshed = err == nil
+	return tmpout, err
+}
+
+// Refreshes the metadata and lists the pending updates
+func (up *FirmwareUpdater) Check() (*[]CommandOutput, error) {
+	var finalOutput = []CommandOutput{}
+	if up.Config.DryRun {
+		return &finalOutput, nil
+	}
+
+	if !up.refreshed {
+		tmpout, err := up.RefreshMetadata()
+		if err != nil {
+			finalOutput = append(finalOutput, *tmpout)
+			return &finalOutput, err
+		}
+	}
+	updates, err := up.PendingUpdates()
+	if err != nil {
+		return &finalOutput, err
+	}
+	for _, update := range updates {
+		tmpout := CommandOutput{}.New(nil, nil)
+		tmpout.Context = fmt.Sprintf("Firmware update available for %s: %s -> %s", update.Device, update.CurrentVersion, update.Version)
+		finalOutput = append(finalOutput, *tmpout)
+	}
+	return &finalOutput, nil
+}
+
+func (up FirmwareUpdater) Update() (*[]CommandOutput, error) {
+	var finalOutput = []CommandOutput{}
+
+	if up.Config.DryRun {
+		return &finalOutput, nil
+	}
+
+	if !up.refreshed {
+		tmpout, err := up.RefreshMetadata()
+		finalOutput = append(finalOutput, *tmpout)
+		if err != nil {
+			return &finalOutput, err
+		}
+	}
+
+	updates, err := up.PendingUpdates()
+	if err != nil {
+		tmpout := CommandOutput{}.New(nil, err)
+		tmpout.Stderr = err
+		tmpout.SetFailureContext("Listing firmware updates")
+		finalOutput = append(finalOutput, *tmpout)
+		return &finalOutput, err
+	}
+
+	var finalErr error = nil
+	for _, update := range updates {
+		context := fmt.Sprintf("Firmware %s: %s -> %s", update.Device, update.CurrentVersion, update.Version)
+		if up.Mode != FirmwareModeInstall {
+			tmpout := CommandOutput{}.New(nil, nil)
+			tmpout.Context = context + " (available)"
+			finalOutput = append(finalOutput, *tmpout)
+			continue
+		}
+
+		// Updates that need a reboot get scheduled by fwupd, we just must not let fwupdmgr reboot on its own
+		cli := []string{up.binaryPath, "update", update.DeviceId, "--assume-yes", "--no-reboot-check"}
+		out, err := session.RunScoped(cli)
+		tmpout := CommandOutput{}.New(out, err)
+		tmpout.Context = context
+		tmpout.Cli = cli
+		tmpout.Failure = err != nil
+		tmpout.RebootRequired = err == nil && update.NeedsReboot
+		finalOutput = append(finalOutput, *tmpout)
+		if err != nil {
+			finalErr = err
+		}
+	}
+	return &finalOutput, finalErr
+}
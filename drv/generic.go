@@ -46,6 +46,11 @@ type CommandOutput struct {
 	Stderr  error
 	Context string
 	Cli     []string
+	// Set when the change only takes effect after a reboot
+	RebootRequired bool
+	// Driver and user the command ran for, logged as the driver and user attributes
+	Driver string
+	User   string
 }
 
 func (output CommandOutput) New(out []byte, err error) *CommandOutput {
@@ -57,6 +62,21 @@ func (output CommandOutput) New(out []byte, err error) *CommandOutput {
 	}
 }
 
+// Marks outputs as coming from the given driver
+func TagDriver(outputs []CommandOutput, config DriverConfiguration) []CommandOutput {
+	for i := range outputs {
+		outputs[i].Driver = strings.ToLower(config.Title)
+	}
+	return outputs
+}
+
+func tagUser(outputs []CommandOutput, name string) []CommandOutput {
+	for i := range outputs {
+		outputs[i].User = name
+	}
+	return outputs
+}
+
 func (out *CommandOutput) SetFailureContext(context string) {
 	out.Failure = true
 	out.Context = context
@@ -0,0 +1,267 @@
+package drv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ublue-os/uupd/pkg/session"
+)
+
+// Boot health of the deployed images, written by `uupd verify-boot`
+const healthStatePath = "/var/lib/uupd/health.json"
+
+type HealthState struct {
+	// Digest of the image the failures were counted for
+	Digest   string `json:"digest"`
+	Failures int    `json:"failures"`
+	// Images that got rolled back, never staged again
+	BadDigests []string `json:"bad_digests"`
+	// Images whose last verified boot failed, they aren't rolled back to
+	FailedDigests []string  `json:"failed_digests"`
+	Time          time.Time `json:"time"`
+}
+
+// What `uupd verify-boot` checks, from UUPD_HEALTH_UNITS, UUPD_HEALTH_SCRIPTS_DIR and UUPD_HEALTH_MAX_FAILURES.
+// Scripts follow greenboot: every executable in the directory has to exit successfully.
+type HealthCheck struct {
+	Units       []string
+	ScriptsDir  string
+	MaxFailures int
+}
+
+type HealthResult struct {
+	Healthy bool
+	Outputs []CommandOutput
+}
+
+func NewHealthCheck(env EnvironmentMap) (HealthCheck, error) {
+	check := HealthCheck{MaxFailures: 3}
+	units, exists := env["UUPD_HEALTH_UNITS"]
+	if exists && units != "" {
+		for _, unit := range strings.Split(units, ",") {
+			check.Units = append(check.Units, strings.TrimSpace(unit))
+		}
+	}
+	scriptsDir, exists := env["UUPD_HEALTH_SCRIPTS_DIR"]
+	if !exists || scriptsDir == "" {
+		check.ScriptsDir = "/etc/uupd/check/required.d"
+	} else {
+		check.ScriptsDir = scriptsDir
+	}
+	maxFailures, exists := env["UUPD_HEALTH_MAX_FAILURES"]
+	if exists && maxFailures != "" {
+		var err error
+		check.MaxFailures, err = strconv.Atoi(maxFailures)
+		if err != nil || check.MaxFailures < 1 {
+			return check, fmt.Errorf("UUPD_HEALTH_MAX_FAILURES must be a positive number, got: %s", maxFailures)
+		}
+	}
+	return check, nil
+}
+
+func (check HealthCheck) scripts() ([]string, error) {
+	entries, err := os.ReadDir(check.ScriptsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var scripts []string
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		scripts = append(scripts, filepath.Join(check.ScriptsDir, entry.Name()))
+	}
+	// ReadDir sorts by name, so scripts can be ordered with numeric prefixes
+	return scripts, nil
+}
+
+// How long units that are still starting get before they count as failed,
+// the check runs while the boot isn't complete yet
+const unitStartTimeout = 5 * time.Minute
+
+type unitStatus struct {
+	ActiveState string
+	// A start job is waiting for the unit's dependencies
+	JobQueued bool
+}
+
+// Whether the unit can still become active
+func (status unitStatus) pending() bool {
+	switch status.ActiveState {
+	case "activating", "reloading":
+		return true
+	case "inactive":
+		return status.JobQueued
+	}
+	return false
+}
+
+func showUnit(unit string) (unitStatus, error) {
+	var status unitStatus
+	out, err := exec.Command("systemctl", "show", "--property=ActiveState,Job", unit).Output()
+	if err != nil {
+		return status, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "ActiveState":
+			status.ActiveState = value
+		case "Job":
+			status.JobQueued = value != "" && value != "0"
+		}
+	}
+	return status, nil
+}
+
+// Polls the unit until it settles or the timeout runs out, returning its last status
+func waitForUnit(unit string, show func(string) (unitStatus, error), timeout time.Duration, interval time.Duration) (unitStatus, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := show(unit)
+		if err != nil || !status.pending() || time.Now().After(deadline) {
+			return status, err
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Checks that every configured unit is active and every script passes
+func (check HealthCheck) Run() HealthResult {
+	result := HealthResult{Healthy: true}
+	for _, unit := range check.Units {
+		cli := []string{"systemctl", "is-active", unit}
+		// is-active gives the verdict once the unit settled, along with its output
+		_, _ = waitForUnit(unit, showUnit, unitStartTimeout, 2*time.Second)
+		out, err := exec.Command(cli[0], cli[1:]...).CombinedOutput()
+		tmpout := CommandOutput{}.New(out, err)
+		tmpout.Context = "Unit " + unit
+		tmpout.Cli = cli
+		tmpout.Failure = err != nil
+		result.Outputs = append(result.Outputs, *tmpout)
+		result.Healthy = result.Healthy && err == nil
+	}
+
+	scripts, err := check.scripts()
+	if err != nil {
+		tmpout := CommandOutput{}.New(nil, err)
+		tmpout.Stderr = err
+		tmpout.SetFailureContext("Listing health check scripts")
+		result.Outputs = append(result.Outputs, *tmpout)
+		result.Healthy = false
+	}
+	for _, script := range scripts {
+		cli := []string{script}
+		cmd := exec.Command(script)
+		out, err := cmd.CombinedOutput()
+		tmpout := CommandOutput{}.New(out, err)
+		tmpout.Context = "Script " + filepath.Base(script)
+		tmpout.Cli = cli
+		tmpout.Failure = err != nil
+		result.Outputs = append(result.Outputs, *tmpout)
+		result.Healthy = result.Healthy && err == nil
+	}
+	return result
+}
+
+// Returns an empty state when no boot has been verified yet
+func LoadHealthState() (HealthState, error) {
+	var state HealthState
+	content, err := os.ReadFile(healthStatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(content, &state)
+	return state, err
+}
+
+func SaveHealthState(state HealthState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(healthStatePath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(healthStatePath, content, 0644)
+}
+
+// Counts a verified boot of digest, returning whether it failed often enough to roll back
+func (state *HealthState) Record(digest string, healthy bool, maxFailures int) bool {
+	if state.Digest != digest {
+		state.Digest = digest
+		state.Failures = 0
+	}
+	state.Time = time.Now()
+	if healthy {
+		state.Failures = 0
+		state.FailedDigests = slices.DeleteFunc(state.FailedDigests, func(failed string) bool { return failed == digest })
+		return false
+	}
+	if digest != "" && !slices.Contains(state.FailedDigests, digest) {
+		state.FailedDigests = append(state.FailedDigests, digest)
+	}
+	state.Failures++
+	return state.Failures >= maxFailures
+}
+
+func (state *HealthState) MarkBad(digest string) {
+	if digest != "" && !slices.Contains(state.BadDigests, digest) {
+		state.BadDigests = append(state.BadDigests, digest)
+	}
+}
+
+func (state HealthState) IsBad(digest string) bool {
+	return digest != "" && slices.Contains(state.BadDigests, digest)
+}
+
+// Whether the image failed its health check when it was last booted or got rolled back,
+// rolling back to it would only trade one broken boot for another
+func (state HealthState) Failed(digest string) bool {
+	return state.IsBad(digest) || (digest != "" && slices.Contains(state.FailedDigests, digest))
+}
+
+// Makes the rollback deployment the default again, it is booted into on the next reboot
+func (dr SystemUpdater) Rollback() (*[]CommandOutput, error) {
+	cli := []string{dr.BinaryPath, "rollback"}
+	out, err := session.RunScoped(cli)
+	tmpout := CommandOutput{}.New(out, err)
+	tmpout.Context = "System rollback"
+	tmpout.Cli = cli
+	tmpout.Failure = err != nil
+	tmpout.RebootRequired = err == nil
+	return &[]CommandOutput{*tmpout}, err
+}
+
+// The update bootc found during the last check if it has been rolled back before
+func (dr SystemUpdater) RejectedUpdate() (*ImageStatus, error) {
+	status, err := dr.Status()
+	if err != nil {
+		return nil, err
+	}
+	state, err := LoadHealthState()
+	if err != nil {
+		return nil, err
+	}
+	update := status.AvailableUpdate()
+	if update == nil || !state.IsBad(update.ImageDigest) {
+		return nil, nil
+	}
+	return update, nil
+}
@@ -0,0 +1,142 @@
+package drv
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForUnit(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []unitStatus
+		want     string
+		// Probes until the unit settled
+		wantProbes int
+	}{
+		{"active", []unitStatus{{ActiveState: "active"}}, "active", 1},
+		{"failed", []unitStatus{{ActiveState: "failed"}}, "failed", 1},
+		{"not enabled", []unitStatus{{ActiveState: "inactive"}}, "inactive", 1},
+		{
+			"start job queued",
+			[]unitStatus{{ActiveState: "inactive", JobQueued: true}, {ActiveState: "activating", JobQueued: true}, {ActiveState: "active"}},
+			"active", 3,
+		},
+		{
+			"reloading",
+			[]unitStatus{{ActiveState: "reloading"}, {ActiveState: "active"}},
+			"active", 2,
+		},
+		{
+			"start failed",
+			[]unitStatus{{ActiveState: "inactive", JobQueued: true}, {ActiveState: "activating"}, {ActiveState: "failed"}},
+			"failed", 3,
+		},
+		{"deactivating", []unitStatus{{ActiveState: "deactivating"}}, "deactivating", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			probes := 0
+			show := func(unit string) (unitStatus, error) {
+				status := test.statuses[min(probes, len(test.statuses)-1)]
+				probes++
+				return status, nil
+			}
+			status, err := waitForUnit("test.service", show, time.Minute, time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.ActiveState != test.want || probes != test.wantProbes {
+				t.Errorf("got %s after %d probes, want %s after %d", status.ActiveState, probes, test.want, test.wantProbes)
+			}
+		})
+	}
+}
+
+func TestWaitForUnitTimeout(t *testing.T) {
+	show := func(unit string) (unitStatus, error) {
+		return unitStatus{ActiveState: "inactive", JobQueued: true}, nil
+	}
+	start := time.Now()
+	status, err := waitForUnit("test.service", show, 20*time.Millisecond, time.Millisecond)
+	if err != nil || !status.pending() {
+		t.Errorf("got %+v, %v", status, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("waited %v past the timeout", time.Since(start))
+	}
+
+	probeErr := errors.New("no bus")
+	_, err = waitForUnit("test.service", func(string) (unitStatus, error) { return unitStatus{}, probeErr }, time.Minute, time.Millisecond)
+	if !errors.Is(err, probeErr) {
+		t.Errorf("got %v, want %v", err, probeErr)
+	}
+}
+
+func TestHealthStateRecord(t *testing.T) {
+	type boot struct {
+		digest       string
+		healthy      bool
+		wantRollback bool
+	}
+	tests := []struct {
+		name       string
+		boots      []boot
+		wantFailed []string
+		wantPassed []string
+	}{
+		{
+			"rolls back after max failures",
+			[]boot{{"sha256:a", false, false}, {"sha256:a", false, false}, {"sha256:a", false, true}},
+			[]string{"sha256:a"}, nil,
+		},
+		{
+			"healthy boot resets the count",
+			[]boot{{"sha256:a", false, false}, {"sha256:a", false, false}, {"sha256:a", true, false}, {"sha256:a", false, false}},
+			[]string{"sha256:a"}, nil,
+		},
+		{
+			"new image starts over",
+			[]boot{{"sha256:a", false, false}, {"sha256:a", false, false}, {"sha256:b", false, false}},
+			[]string{"sha256:a", "sha256:b"}, nil,
+		},
+		{
+			"healthy boot clears the image",
+			[]boot{{"sha256:a", false, false}, {"sha256:b", true, false}, {"sha256:a", true, false}},
+			nil, []string{"sha256:a", "sha256:b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var state HealthState
+			for i, boot := range test.boots {
+				if got := state.Record(boot.digest, boot.healthy, 3); got != boot.wantRollback {
+					t.Errorf("boot %d: rollback %v, want %v", i, got, boot.wantRollback)
+				}
+			}
+			for _, digest := range test.wantFailed {
+				if !state.Failed(digest) {
+					t.Errorf("%s not counted as failed: %+v", digest, state)
+				}
+			}
+			for _, digest := range test.wantPassed {
+				if state.Failed(digest) {
+					t.Errorf("%s counted as failed: %+v", digest, state)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthStateMarkBad(t *testing.T) {
+	var state HealthState
+	state.MarkBad("")
+	state.MarkBad("sha256:a")
+	state.MarkBad("sha256:a")
+	if len(state.BadDigests) != 1 || !state.IsBad("sha256:a") || !state.Failed("sha256:a") {
+		t.Errorf("got %+v", state)
+	}
+	if state.IsBad("") || state.Failed("") || state.Failed("sha256:b") {
+		t.Errorf("unknown digests counted as bad: %+v", state)
+	}
+}
@@ -0,0 +1,191 @@
+package drv
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/ublue-os/uupd/pkg/percent"
+	"github.com/ublue-os/uupd/pkg/session"
+)
+
+var nixVersionRegex = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+type NixUpdater struct {
+	Config       DriverConfiguration
+	Tracker      *TrackerConfiguration
+	binaryDir    string
+	daemon       bool
+	users        []session.User
+	usersEnabled bool
+}
+
+func (up NixUpdater) Steps() int {
+	if up.Config.Enabled {
+		var steps = 1
+		if up.usersEnabled {
+			steps += len(up.users)
+		}
+		return steps
+	}
+	return 0
+}
+
+func (up NixUpdater) New(config UpdaterInitConfiguration) (NixUpdater, error) {
+	userdesc := "Nix profile for User:"
+	up.Config = DriverConfiguration{
+		Title:           "Nix",
+		Description:     "Nix Channels",
+		UserDescription: &userdesc,
+		Enabled:         true,
+		MultiUser:       true,
+		DryRun:          config.DryRun,
+		Environment:     config.Environment,
+	}
+	up.usersEnabled = false
+	up.Tracker = nil
+
+	binaryDir, exists := up.Config.Environment["UUPD_NIX_BINARY_DIR"]
+	if !exists || binaryDir == "" {
+		up.binaryDir = "/nix/var/nix/profiles/default/bin"
+	} else {
+		up.binaryDir = binaryDir
+	}
+
+	if up.Config.DryRun {
+		return up, nil
+	}
+
+	_, err := os.Stat(filepath.Join(up.binaryDir, "nix"))
+	if err != nil {
+		return up, err
+	}
+	// Multi-user installs are driven by nix-daemon, single-user ones are owned by a single user
+	_, err = os.Stat("/nix/var/nix/daemon-socket/socket")
+	up.daemon = err == nil
+
+	return up, nil
+}
+
+func (up *NixUpdater) SetUsers(users []session.User) {
+	up.users = users
+	up.usersEnabled = true
+}
+
+func (up NixUpdater) Check() (*[]CommandOutput, error) {
+	return nil, nil
+}
+
+func (up NixUpdater) bin(name string) string {
+	return filepath.Join(up.binaryDir, name)
+}
+
+// Caps the download speed of nix and nix-env, nix-channel has no way to set it
+func (up NixUpdater) limited(cli []string) []string {
+	limit := session.DownloadLimit()
+	if limit == 0 {
+		return cli
+	}
+	return append(cli, "--option", "download-speed", strconv.Itoa(limit))
+}
+
+// `nix profile upgrade` takes --all since Nix 2.20, older versions only understand regexes
+func (up NixUpdater) profileUpgradeCli() []string {
+	cli := []string{up.bin("nix"), "--extra-experimental-features", "nix-command flakes", "profile", "upgrade"}
+	out, err := session.RunUID(0, []string{up.bin("nix"), "--version"}, nil)
+	if err == nil {
+		match := nixVersionRegex.FindStringSubmatch(string(out))
+		if match != nil {
+			major, _ := strconv.Atoi(match[1])
+			minor, _ := strconv.Atoi(match[2])
+			if major > 2 || (major == 2 && minor >= 20) {
+				return append(cli, "--all")
+			}
+		}
+	}
+	return append(cli, ".*")
+}
+
+// Upgrades a user's profile with either the new-style `nix profile` or legacy `nix-env`,
+// depending on which one manages ~/.nix-profile
+func (up NixUpdater) updateUser(usr session.User, context string) []CommandOutput {
+	var outputs = []CommandOutput{}
+
+	info, err := user.LookupId(strconv.Itoa(usr.UID))
+	if err != nil {
+		tmpout := CommandOutput{}.New(nil, err)
+		tmpout.Stderr = err
+		tmpout.SetFailureContext(context)
+		return append(outputs, *tmpout)
+	}
+
+	profile, err := filepath.EvalSymlinks(filepath.Join(info.HomeDir, ".nix-profile"))
+	if err != nil {
+		// User doesn't use Nix
+		return outputs
+	}
+
+	_, err = os.Stat(filepath.Join(info.HomeDir, ".nix-channels"))
+	if err == nil {
+		cli := []string{up.bin("nix-channel"), "--update"}
+		out, err := session.RunUID(usr.UID, cli, nil)
+		tmpout := CommandOutput{}.New(out, err)
+		tmpout.Context = "Nix channels for User: " + usr.Name
+		tmpout.Cli = cli
+		tmpout.Failure = err != nil
+		outputs = append(outputs, *tmpout)
+	}
+
+	var cli []string
+	_, err = os.Stat(filepath.Join(profile, "manifest.json"))
+	if err == nil {
+		cli = up.profileUpgradeCli()
+	} else {
+		cli = []string{up.bin("nix-env"), "--upgrade"}
+	}
+	cli = up.limited(cli)
+	out, err := session.RunUID(usr.UID, cli, nil)
+	tmpout := CommandOutput{}.New(out, err)
+	tmpout.Context = context
+	tmpout.Cli = cli
+	tmpout.Failure = err != nil
+	return append(outputs, *tmpout)
+}
+
+func (up *NixUpdater) Update() (*[]CommandOutput, error) {
+	var finalOutput = []CommandOutput{}
+
+	if up.Config.DryRun {
+		percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: up.Config.Description})
+		up.Tracker.Tracker.IncrementSection(nil)
+
+		var err error = nil
+		for _, user := range up.users {
+			up.Tracker.Tracker.IncrementSection(err)
+			percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: *up.Config.UserDescription + " " + user.Name})
+		}
+		return &finalOutput, nil
+	}
+
+	percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: up.Config.Description})
+	if up.daemon {
+		cli := []string{up.bin("nix-channel"), "--update"}
+		out, err := session.RunUID(0, cli, nil)
+		tmpout := CommandOutput{}.New(out, err)
+		tmpout.Context = up.Config.Description
+		tmpout.Cli = cli
+		tmpout.Failure = err != nil
+		finalOutput = append(finalOutput, *tmpout)
+	}
+
+	var err error = nil
+	for _, user := range up.users {
+		up.Tracker.Tracker.IncrementSection(err)
+		context := *up.Config.UserDescription + " " + user.Name
+		percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: context})
+		finalOutput = append(finalOutput, tagUser(up.updateUser(user, context), user.Name)...)
+	}
+	return &finalOutput, nil
+}
@@ -0,0 +1,141 @@
+package drv
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type OutdatedLevel int
+
+const (
+	OutdatedNone OutdatedLevel = iota
+	// Soft notification
+	OutdatedNotify
+	// Persistent warning
+	OutdatedWarn
+	// Update regardless of maintenance windows, the rollout delay and hardware checks
+	OutdatedForce
+)
+
+func (level OutdatedLevel) String() string {
+	switch level {
+	case OutdatedNotify:
+		return "notify"
+	case OutdatedWarn:
+		return "warn"
+	case OutdatedForce:
+		return "force"
+	}
+	return "none"
+}
+
+func (level OutdatedLevel) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+// Image age thresholds, a zero threshold disables that level
+type OutdatedPolicy struct {
+	Notify time.Duration
+	Warn   time.Duration
+	Force  time.Duration
+}
+
+func parseDays(env EnvironmentMap, key string, fallback int) (time.Duration, error) {
+	days := fallback
+	value, exists := env[key]
+	if exists && value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("%s: invalid amount of days: %s", key, value)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// Reads UUPD_OUTDATED_{NOTIFY,WARN,FORCE}_DAYS, only the notification is enabled by default (30 days)
+func NewOutdatedPolicy(env EnvironmentMap) (OutdatedPolicy, error) {
+	var policy OutdatedPolicy
+	var err error
+	policy.Notify, err = parseDays(env, "UUPD_OUTDATED_NOTIFY_DAYS", 30)
+	if err != nil {
+		return policy, err
+	}
+	policy.Warn, err = parseDays(env, "UUPD_OUTDATED_WARN_DAYS", 0)
+	if err != nil {
+		return policy, err
+	}
+	policy.Force, err = parseDays(env, "UUPD_OUTDATED_FORCE_DAYS", 0)
+	return policy, err
+}
+
+func (policy OutdatedPolicy) Level(age time.Duration) OutdatedLevel {
+	switch {
+	case policy.Force > 0 && age >= policy.Force:
+		return OutdatedForce
+	case policy.Warn > 0 && age >= policy.Warn:
+		return OutdatedWarn
+	case policy.Notify > 0 && age >= policy.Notify:
+		return OutdatedNotify
+	}
+	return OutdatedNone
+}
+
+// Age of a deployed image
+type ImageAge struct {
+	// "booted" or "staged"
+	Deployment string        `json:"deployment"`
+	Version    string        `json:"version,omitempty"`
+	BuildTime  time.Time     `json:"build_time"`
+	Age        time.Duration `json:"-"`
+	AgeDays    int           `json:"age_days"`
+	Level      OutdatedLevel `json:"level"`
+}
+
+func newImageAge(deployment string, version string, buildTime time.Time, policy OutdatedPolicy) ImageAge {
+	age := time.Since(buildTime)
+	return ImageAge{
+		Deployment: deployment,
+		Version:    version,
+		BuildTime:  buildTime,
+		Age:        age,
+		AgeDays:    int(age.Hours() / 24),
+		Level:      policy.Level(age),
+	}
+}
+
+type OutdatedReport struct {
+	Booted *ImageAge `json:"booted"`
+	Staged *ImageAge `json:"staged,omitempty"`
+	// What should be done about it: a staged image lowers the level to its own,
+	// since rebooting is enough to get back up to date
+	Level OutdatedLevel `json:"level"`
+}
+
+func newOutdatedReport(booted *ImageAge, staged *ImageAge) OutdatedReport {
+	report := OutdatedReport{Booted: booted, Staged: staged}
+	if booted != nil {
+		report.Level = booted.Level
+	}
+	if staged != nil && staged.Level < report.Level {
+		report.Level = staged.Level
+	}
+	return report
+}
+
+// Whether a staged deployment is enough to get rid of the warning once rebooted into
+func (report OutdatedReport) RebootFixes() bool {
+	return report.Booted != nil && report.Staged != nil && report.Staged.Level < report.Booted.Level
+}
+
+func (report OutdatedReport) Message() string {
+	if report.Booted == nil || report.Booted.Level == OutdatedNone {
+		return ""
+	}
+	days := report.Booted.AgeDays
+	if report.RebootFixes() {
+		return fmt.Sprintf("The running system image is %d days old, a newer one is staged. Reboot to apply it", days)
+	}
+	return fmt.Sprintf("There hasn't been an update in %d days. Consider rebooting or running updates manually", days)
+}
@@ -0,0 +1,94 @@
+package drv
+
+import (
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+func TestOutdatedPolicyLevel(t *testing.T) {
+	full := OutdatedPolicy{Notify: 30 * day, Warn: 60 * day, Force: 90 * day}
+	tests := []struct {
+		name   string
+		policy OutdatedPolicy
+		age    time.Duration
+		want   OutdatedLevel
+	}{
+		{"fresh", full, 2 * day, OutdatedNone},
+		{"notify threshold", full, 30 * day, OutdatedNotify},
+		{"warn", full, 75 * day, OutdatedWarn},
+		{"force", full, 120 * day, OutdatedForce},
+		{"warn disabled", OutdatedPolicy{Notify: 30 * day, Force: 90 * day}, 75 * day, OutdatedNotify},
+		{"notify disabled", OutdatedPolicy{Warn: 60 * day}, 45 * day, OutdatedNone},
+		{"all disabled", OutdatedPolicy{}, 365 * day, OutdatedNone},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.Level(test.age); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewOutdatedPolicy(t *testing.T) {
+	policy, err := NewOutdatedPolicy(nil)
+	if err != nil || policy != (OutdatedPolicy{Notify: 30 * day}) {
+		t.Errorf("defaults: got %+v, %v", policy, err)
+	}
+	policy, err = NewOutdatedPolicy(EnvironmentMap{"UUPD_OUTDATED_NOTIFY_DAYS": "0", "UUPD_OUTDATED_WARN_DAYS": "14", "UUPD_OUTDATED_FORCE_DAYS": "60"})
+	if err != nil || policy != (OutdatedPolicy{Warn: 14 * day, Force: 60 * day}) {
+		t.Errorf("got %+v, %v", policy, err)
+	}
+	for _, value := range []string{"-1", "a month"} {
+		_, err = NewOutdatedPolicy(EnvironmentMap{"UUPD_OUTDATED_WARN_DAYS": value})
+		if err == nil {
+			t.Errorf("%q accepted", value)
+		}
+	}
+}
+
+func TestNewOutdatedReport(t *testing.T) {
+	policy := OutdatedPolicy{Notify: 30 * day, Warn: 60 * day}
+	age := func(deployment string, days int) *ImageAge {
+		imageAge := newImageAge(deployment, "", time.Now().Add(-time.Duration(days)*day), policy)
+		return &imageAge
+	}
+	tests := []struct {
+		name            string
+		booted          *ImageAge
+		staged          *ImageAge
+		want            OutdatedLevel
+		wantRebootFixes bool
+	}{
+		{"unknown", nil, nil, OutdatedNone, false},
+		{"booted fresh", age("booted", 3), nil, OutdatedNone, false},
+		{"booted outdated", age("booted", 70), nil, OutdatedWarn, false},
+		{"newer image staged", age("booted", 70), age("staged", 1), OutdatedNone, true},
+		{"staged image outdated too", age("booted", 70), age("staged", 40), OutdatedNotify, true},
+		{"staged image as old", age("booted", 70), age("staged", 65), OutdatedWarn, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := newOutdatedReport(test.booted, test.staged)
+			if report.Level != test.want {
+				t.Errorf("level %s, want %s", report.Level, test.want)
+			}
+			if report.RebootFixes() != test.wantRebootFixes {
+				t.Errorf("RebootFixes %v, want %v", report.RebootFixes(), test.wantRebootFixes)
+			}
+		})
+	}
+
+	if got := newOutdatedReport(age("booted", 3), nil).Message(); got != "" {
+		t.Errorf("message for a fresh image: %q", got)
+	}
+	booted := age("booted", 45)
+	if got := newOutdatedReport(booted, nil).Message(); got != "There hasn't been an update in 45 days. Consider rebooting or running updates manually" {
+		t.Errorf("got %q", got)
+	}
+	if got := newOutdatedReport(booted, age("staged", 1)).Message(); got != "The running system image is 45 days old, a newer one is staged. Reboot to apply it" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,77 @@
+package drv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	PhaseAll      = ""
+	PhaseDownload = "download"
+	PhaseApply    = "apply"
+)
+
+const phaseStatePath = "/var/lib/uupd/phase.json"
+
+// What the download phase left behind for the apply phase
+type PhaseState struct {
+	// Image tracked by the system when it got downloaded
+	Image string `json:"image"`
+	// Whether the deployment was only downloaded and still has to be unlocked/finalized
+	DownloadOnly bool      `json:"download_only"`
+	Time         time.Time `json:"time"`
+}
+
+func ValidatePhase(phase string) error {
+	switch phase {
+	case PhaseAll, PhaseDownload, PhaseApply:
+		return nil
+	}
+	return fmt.Errorf("Invalid phase: %s, expected %s or %s", phase, PhaseDownload, PhaseApply)
+}
+
+// Returns nil when nothing has been downloaded
+func LoadPhaseState() (*PhaseState, error) {
+	content, err := os.ReadFile(phaseStatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state PhaseState
+	err = json.Unmarshal(content, &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func SavePhaseState(state PhaseState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(phaseStatePath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(phaseStatePath, content, 0644)
+}
+
+func ClearPhaseState() error {
+	err := os.Remove(phaseStatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Whether the apply phase should reboot into the new deployment, from UUPD_REBOOT_POLICY
+func rebootOnApply(env EnvironmentMap) bool {
+	return env["UUPD_REBOOT_POLICY"] == "apply"
+}
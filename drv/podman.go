@@ -0,0 +1,164 @@
+package drv
+
+import (
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ublue-os/uupd/pkg/percent"
+	"github.com/ublue-os/uupd/pkg/session"
+)
+
+// Label toolbox puts on every container it creates
+const toolboxLabel = "com.github.containers.toolbox=true"
+
+// Refreshes the images backing toolbox containers and runs `podman auto-update`
+// for containers labeled with io.containers.autoupdate.
+// Existing toolboxes keep running on their old image until they are recreated.
+type PodmanUpdater struct {
+	Config       DriverConfiguration
+	Tracker      *TrackerConfiguration
+	binaryPath   string
+	authFile     string
+	users        []session.User
+	usersEnabled bool
+}
+
+func (up PodmanUpdater) Steps() int {
+	if up.Config.Enabled {
+		var steps = 1
+		if up.usersEnabled {
+			steps += len(up.users)
+		}
+		return steps
+	}
+	return 0
+}
+
+func (up PodmanUpdater) New(config UpdaterInitConfiguration) (PodmanUpdater, error) {
+	userdesc := "Containers for User:"
+	up.Config = DriverConfiguration{
+		Title:           "Podman",
+		Description:     "Rootful Containers",
+		UserDescription: &userdesc,
+		Enabled:         true,
+		MultiUser:       true,
+		DryRun:          config.DryRun,
+		Environment:     config.Environment,
+	}
+	up.usersEnabled = false
+	up.Tracker = nil
+
+	binaryPath, exists := up.Config.Environment["UUPD_PODMAN_BINARY"]
+	if !exists || binaryPath == "" {
+		up.binaryPath = "/usr/bin/podman"
+	} else {
+		up.binaryPath = binaryPath
+	}
+
+	// Only used for rootful containers, rootless podman can't read root's credentials
+	up.authFile = up.Config.Environment["UUPD_REGISTRY_AUTH_FILE"]
+
+	if up.Config.DryRun {
+		return up, nil
+	}
+
+	_, err := os.Stat(up.binaryPath)
+	if err != nil {
+		return up, err
+	}
+
+	return up, nil
+}
+
+func (up *PodmanUpdater) SetUsers(users []session.User) {
+	up.users = users
+	up.usersEnabled = true
+}
+
+func (up PodmanUpdater) Check() (*[]CommandOutput, error) {
+	return nil, nil
+}
+
+// Lists the images used by toolbox containers owned by uid, without duplicates
+func (up PodmanUpdater) toolboxImages(uid int) ([]string, error) {
+	cli := []string{up.binaryPath, "ps", "--all", "--filter", "label=" + toolboxLabel, "--format", "{{.Image}}"}
+	out, err := session.RunUID(uid, cli, nil)
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	for _, line := range strings.Split(string(out), "\n") {
+		image := strings.TrimSpace(line)
+		if image == "" || slices.Contains(images, image) {
+			continue
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// Pulls toolbox images and runs podman auto-update for a single user
+func (up PodmanUpdater) updateUser(uid int, context string) []CommandOutput {
+	var outputs = []CommandOutput{}
+	var authArgs []string
+	if uid == 0 && up.authFile != "" {
+		authArgs = []string{"--authfile", up.authFile}
+	}
+
+	images, err := up.toolboxImages(uid)
+	if err != nil {
+		tmpout := CommandOutput{}.New(nil, err)
+		tmpout.Stderr = err
+		tmpout.SetFailureContext("Listing toolboxes for " + context)
+		outputs = append(outputs, *tmpout)
+	}
+	for _, image := range images {
+		cli := append([]string{up.binaryPath, "pull"}, authArgs...)
+		cli = append(cli, image)
+		out, err := session.RunUID(uid, cli, nil)
+		tmpout := CommandOutput{}.New(out, err)
+		tmpout.Context = "Toolbox image " + image + " for " + context
+		tmpout.Cli = cli
+		tmpout.Failure = err != nil
+		outputs = append(outputs, *tmpout)
+	}
+
+	cli := append([]string{up.binaryPath, "auto-update"}, authArgs...)
+	out, err := session.RunUID(uid, cli, nil)
+	tmpout := CommandOutput{}.New(out, err)
+	tmpout.Context = context
+	tmpout.Cli = cli
+	tmpout.Failure = err != nil
+	outputs = append(outputs, *tmpout)
+
+	return outputs
+}
+
+func (up *PodmanUpdater) Update() (*[]CommandOutput, error) {
+	var finalOutput = []CommandOutput{}
+
+	if up.Config.DryRun {
+		percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: up.Config.Description})
+		up.Tracker.Tracker.IncrementSection(nil)
+
+		var err error = nil
+		for _, user := range up.users {
+			up.Tracker.Tracker.IncrementSection(err)
+			percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: *up.Config.UserDescription + " " + user.Name})
+		}
+		return &finalOutput, nil
+	}
+
+	percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: up.Config.Description})
+	finalOutput = append(finalOutput, up.updateUser(0, up.Config.Description)...)
+
+	var err error = nil
+	for _, user := range up.users {
+		up.Tracker.Tracker.IncrementSection(err)
+		context := *up.Config.UserDescription + " " + user.Name
+		percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: context})
+		finalOutput = append(finalOutput, tagUser(up.updateUser(user.UID, context), user.Name)...)
+	}
+	return &finalOutput, nil
+}
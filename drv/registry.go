@@ -0,0 +1,192 @@
+package drv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bootc and rpm-ostree look for credentials here before /etc/ostree/auth.json
+const ostreeRuntimeAuthPath = "/run/ostree/auth.json"
+
+// Drop-in read by bootc, rpm-ostree and podman, see containers-registries.conf.d(5)
+const mirrorsConfigPath = "/etc/containers/registries.conf.d/50-uupd-mirrors.conf"
+
+// Registry Universal Blue images are published to, mirrored when UUPD_REGISTRY_MIRRORS doesn't name one
+const defaultMirroredRegistry = "ghcr.io"
+
+// A mirror stands in for the registry host, e.g. with "mirror.lan/ghcr"
+// ghcr.io/ublue-os/bluefin:stable is pulled as mirror.lan/ghcr/ublue-os/bluefin:stable.
+type RegistryMirror struct {
+	Registry string
+	Location string
+}
+
+// Registry mirrors and credentials, from UUPD_REGISTRY_MIRRORS and UUPD_REGISTRY_AUTH_FILE.
+// Mirrors are given as registry=mirror, or as just the mirror for ghcr.io.
+type RegistryConfiguration struct {
+	Mirrors  []RegistryMirror
+	AuthFile string
+	client   *http.Client
+}
+
+func NewRegistryConfiguration(env EnvironmentMap) RegistryConfiguration {
+	config := RegistryConfiguration{
+		AuthFile: env["UUPD_REGISTRY_AUTH_FILE"],
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	mirrors, exists := env["UUPD_REGISTRY_MIRRORS"]
+	if exists && mirrors != "" {
+		for _, entry := range strings.Split(mirrors, ",") {
+			registry, location, found := strings.Cut(entry, "=")
+			if !found {
+				registry, location = defaultMirroredRegistry, registry
+			}
+			location = strings.TrimSuffix(strings.TrimSpace(location), "/")
+			if location == "" {
+				continue
+			}
+			config.Mirrors = append(config.Mirrors, RegistryMirror{Registry: strings.TrimSpace(registry), Location: location})
+		}
+	}
+	return config
+}
+
+// Registry host of an image reference, docker.io if there is none
+func RegistryHost(ref string) string {
+	slash := strings.Index(ref, "/")
+	if slash == -1 {
+		return "docker.io"
+	}
+	host := ref[:slash]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return "docker.io"
+	}
+	return host
+}
+
+// Mirrors of the registry, in the order they are tried
+func (config RegistryConfiguration) MirrorsOf(registry string) []string {
+	var locations []string
+	for _, mirror := range config.Mirrors {
+		if mirror.Registry == registry {
+			locations = append(locations, mirror.Location)
+		}
+	}
+	return locations
+}
+
+// Any answer from the registry API counts, even 401
+func (config RegistryConfiguration) Reachable(host string) bool {
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	response, err := config.client.Get("https://" + host + "/v2/")
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode < 500
+}
+
+// Registries that have mirrors, in the order they were configured
+func (config RegistryConfiguration) mirroredRegistries() []string {
+	var registries []string
+	for _, mirror := range config.Mirrors {
+		if !slices.Contains(registries, mirror.Registry) {
+			registries = append(registries, mirror.Registry)
+		}
+	}
+	return registries
+}
+
+// A [[registry]] table per registry, image references keep pointing to the registry
+// so that signatures are still checked against its policy.json entry
+func (config RegistryConfiguration) mirrorsConfig(registries []string) string {
+	var b strings.Builder
+	b.WriteString("# Written by uupd from UUPD_REGISTRY_MIRRORS, removed once the run is over\n")
+	for _, registry := range registries {
+		fmt.Fprintf(&b, "\n[[registry]]\nlocation = %s\n", strconv.Quote(registry))
+		for _, location := range config.MirrorsOf(registry) {
+			fmt.Fprintf(&b, "\n[[registry.mirror]]\nlocation = %s\n", strconv.Quote(location))
+		}
+	}
+	return b.String()
+}
+
+// Makes the auth file and the mirrors of unreachable registries visible to bootc, rpm-ostree
+// and podman for the duration of the run, returning the registries that fell back to their mirrors.
+// The returned function removes the files again. Both are shared with every uupd process,
+// so only the holder of the uupd lock may install them.
+func (config RegistryConfiguration) Install() (func(), []string, error) {
+	removeAuthFile, authErr := config.installAuthFile()
+	removeMirrors, fallbacks, mirrorsErr := config.installMirrors()
+	return func() {
+		removeAuthFile()
+		removeMirrors()
+	}, fallbacks, errors.Join(authErr, mirrorsErr)
+}
+
+// containers/image tries mirrors before the registry, so they are only configured
+// for registries that don't answer when the run starts
+func (config RegistryConfiguration) installMirrors() (func(), []string, error) {
+	noop := func() {}
+	var fallbacks []string
+	for _, registry := range config.mirroredRegistries() {
+		if !config.Reachable(registry) {
+			fallbacks = append(fallbacks, registry)
+		}
+	}
+	if len(fallbacks) == 0 {
+		// Left behind by a run that got killed
+		err := os.Remove(mirrorsConfigPath)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return noop, nil, err
+	}
+	err := os.MkdirAll(filepath.Dir(mirrorsConfigPath), 0755)
+	if err != nil {
+		return noop, nil, err
+	}
+	err = os.WriteFile(mirrorsConfigPath, []byte(config.mirrorsConfig(fallbacks)), 0644)
+	if err != nil {
+		return noop, nil, err
+	}
+	return func() {
+		_ = os.Remove(mirrorsConfigPath)
+	}, fallbacks, nil
+}
+
+// Existing credentials in /run are left alone
+func (config RegistryConfiguration) installAuthFile() (func(), error) {
+	noop := func() {}
+	if config.AuthFile == "" {
+		return noop, nil
+	}
+	_, err := os.Stat(ostreeRuntimeAuthPath)
+	if err == nil {
+		return noop, nil
+	}
+	content, err := os.ReadFile(config.AuthFile)
+	if err != nil {
+		return noop, err
+	}
+	err = os.MkdirAll(filepath.Dir(ostreeRuntimeAuthPath), 0700)
+	if err != nil {
+		return noop, err
+	}
+	err = os.WriteFile(ostreeRuntimeAuthPath, content, 0600)
+	if err != nil {
+		return noop, err
+	}
+	return func() {
+		_ = os.Remove(ostreeRuntimeAuthPath)
+	}, nil
+}
@@ -0,0 +1,72 @@
+package drv
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNewRegistryConfigurationMirrors(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []RegistryMirror
+	}{
+		{"", nil},
+		{"mirror.lan/ghcr", []RegistryMirror{{"ghcr.io", "mirror.lan/ghcr"}}},
+		{
+			"quay.io=mirror.lan/quay/, mirror.lan/ghcr ,,ghcr.io=backup.lan:5000",
+			[]RegistryMirror{{"quay.io", "mirror.lan/quay"}, {"ghcr.io", "mirror.lan/ghcr"}, {"ghcr.io", "backup.lan:5000"}},
+		},
+		{"docker.io=", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			config := NewRegistryConfiguration(EnvironmentMap{"UUPD_REGISTRY_MIRRORS": test.value})
+			if !slices.Equal(config.Mirrors, test.want) {
+				t.Errorf("got %+v, want %+v", config.Mirrors, test.want)
+			}
+		})
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"ghcr.io/ublue-os/bluefin:stable": "ghcr.io",
+		"localhost/bluefin":               "localhost",
+		"registry.lan:5000/bluefin":       "registry.lan:5000",
+		"library/fedora":                  "docker.io",
+		"fedora":                          "docker.io",
+	}
+	for ref, want := range tests {
+		if got := RegistryHost(ref); got != want {
+			t.Errorf("%s: got %s, want %s", ref, got, want)
+		}
+	}
+}
+
+func TestMirrorsConfig(t *testing.T) {
+	config := NewRegistryConfiguration(EnvironmentMap{"UUPD_REGISTRY_MIRRORS": "mirror.lan/ghcr,quay.io=mirror.lan/quay,ghcr.io=backup.lan,docker.io=mirror.lan/docker"})
+	registries := config.mirroredRegistries()
+	if !slices.Equal(registries, []string{"ghcr.io", "quay.io", "docker.io"}) {
+		t.Errorf("got registries %q", registries)
+	}
+	want := `# Written by uupd from UUPD_REGISTRY_MIRRORS, removed once the run is over
+
+[[registry]]
+location = "ghcr.io"
+
+[[registry.mirror]]
+location = "mirror.lan/ghcr"
+
+[[registry.mirror]]
+location = "backup.lan"
+
+[[registry]]
+location = "quay.io"
+
+[[registry.mirror]]
+location = "mirror.lan/quay"
+`
+	if got := config.mirrorsConfig(registries[:2]); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
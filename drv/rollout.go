@@ -0,0 +1,141 @@
+package drv
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const machineIdPath = "/etc/machine-id"
+
+// First update held back since the booted image, written by `uupd` and `uupd update-check`
+const rolloutStatePath = "/var/lib/uupd/rollout.json"
+
+// Holds back new system images until they are old enough, so that a fleet doesn't
+// update all at once. Every machine waits Delay plus its own share of Jitter,
+// derived from /etc/machine-id so that it stays the same between runs.
+type RolloutPolicy struct {
+	Delay  time.Duration
+	Jitter time.Duration
+	offset time.Duration
+}
+
+func parseHours(env EnvironmentMap, key string) (time.Duration, error) {
+	value, exists := env[key]
+	if !exists || value == "" {
+		return 0, nil
+	}
+	hours, err := strconv.Atoi(value)
+	if err != nil || hours < 0 {
+		return 0, fmt.Errorf("%s: invalid amount of hours: %s", key, value)
+	}
+	return time.Duration(hours) * time.Hour, nil
+}
+
+// Reads UUPD_ROLLOUT_DELAY_HOURS and UUPD_ROLLOUT_JITTER_HOURS, both disabled by default
+func NewRolloutPolicy(env EnvironmentMap) (RolloutPolicy, error) {
+	var policy RolloutPolicy
+	var err error
+	policy.Delay, err = parseHours(env, "UUPD_ROLLOUT_DELAY_HOURS")
+	if err != nil {
+		return policy, err
+	}
+	policy.Jitter, err = parseHours(env, "UUPD_ROLLOUT_JITTER_HOURS")
+	if err != nil || policy.Jitter == 0 {
+		return policy, err
+	}
+
+	machineId, err := os.ReadFile(machineIdPath)
+	if err != nil || strings.TrimSpace(string(machineId)) == "" {
+		// Without an id to place it in a wave, the machine goes with the last one
+		policy.offset = policy.Jitter
+		return policy, nil
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(string(machineId))))
+	policy.offset = time.Duration(binary.BigEndian.Uint64(sum[:8]) % uint64(policy.Jitter))
+	return policy, nil
+}
+
+// How long this machine waits after an image is built before taking it
+func (policy RolloutPolicy) Wait() time.Duration {
+	return policy.Delay + policy.offset
+}
+
+// When this machine may take the image, images without a build time are taken right away
+func (policy RolloutPolicy) EligibleAt(image *ImageStatus) time.Time {
+	if image == nil || policy.Wait() == 0 {
+		return time.Time{}
+	}
+	buildTime, err := image.BuildTime()
+	if err != nil || buildTime.IsZero() {
+		return time.Time{}
+	}
+	return buildTime.Add(policy.Wait())
+}
+
+func (policy RolloutPolicy) Eligible(image *ImageStatus) bool {
+	return !time.Now().Before(policy.EligibleAt(image))
+}
+
+// The first update held back for the booted image. bootc only caches the newest build, so
+// with images rebuilt more often than the delay the cached one would never become old enough.
+// Once the first held back build would have been eligible, the newest one is taken instead.
+type RolloutState struct {
+	// Digest of the booted image the update was held back on
+	Booted    string    `json:"booted"`
+	Digest    string    `json:"digest"`
+	BuildTime time.Time `json:"build_time"`
+}
+
+// Returns an empty state when no update has been held back yet
+func LoadRolloutState() (RolloutState, error) {
+	var state RolloutState
+	content, err := os.ReadFile(rolloutStatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(content, &state)
+	return state, err
+}
+
+func SaveRolloutState(state RolloutState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(rolloutStatePath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(rolloutStatePath, content, 0644)
+}
+
+// When this machine may take update, remembering it in state if it is the first one held back
+// since booted. Returns whether state changed.
+func (policy RolloutPolicy) Hold(state *RolloutState, booted string, update *ImageStatus) (time.Time, bool) {
+	eligibleAt := policy.EligibleAt(update)
+	if eligibleAt.IsZero() {
+		return eligibleAt, false
+	}
+	changed := false
+	if state.Booted != booted || state.BuildTime.IsZero() {
+		buildTime, _ := update.BuildTime()
+		*state = RolloutState{Booted: booted, Digest: update.ImageDigest, BuildTime: buildTime}
+		changed = true
+	}
+	held := state.BuildTime.Add(policy.Wait())
+	if held.Before(eligibleAt) {
+		return held, changed
+	}
+	return eligibleAt, changed
+}
@@ -0,0 +1,138 @@
+package drv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHours(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"48", 48 * time.Hour, false},
+		{"-1", 0, true},
+		{"1.5", 0, true},
+		{"2d", 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseHours(EnvironmentMap{"UUPD_ROLLOUT_DELAY_HOURS": test.value}, "UUPD_ROLLOUT_DELAY_HOURS")
+			if (err != nil) != test.wantErr || got != test.want {
+				t.Errorf("got %v, %v, want %v, error %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestRolloutEligibleAt(t *testing.T) {
+	built := time.Now().Add(-36 * time.Hour).UTC().Truncate(time.Second)
+	image := &ImageStatus{Timestamp: built.Format(time.RFC3339Nano)}
+	tests := []struct {
+		name         string
+		policy       RolloutPolicy
+		image        *ImageStatus
+		wantAt       time.Time
+		wantEligible bool
+	}{
+		{"disabled", RolloutPolicy{}, image, time.Time{}, true},
+		{"delay passed", RolloutPolicy{Delay: 24 * time.Hour}, image, built.Add(24 * time.Hour), true},
+		{"delay pending", RolloutPolicy{Delay: 48 * time.Hour}, image, built.Add(48 * time.Hour), false},
+		{"jitter pushes past now", RolloutPolicy{Delay: 24 * time.Hour, Jitter: 24 * time.Hour, offset: 20 * time.Hour}, image, built.Add(44 * time.Hour), false},
+		{"jitter alone", RolloutPolicy{Jitter: 24 * time.Hour, offset: 6 * time.Hour}, image, built.Add(6 * time.Hour), true},
+		{"no build time", RolloutPolicy{Delay: 48 * time.Hour}, &ImageStatus{}, time.Time{}, true},
+		{"unparsable build time", RolloutPolicy{Delay: 48 * time.Hour}, &ImageStatus{Timestamp: "yesterday"}, time.Time{}, true},
+		{"no image", RolloutPolicy{Delay: 48 * time.Hour}, nil, time.Time{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.EligibleAt(test.image); !got.Equal(test.wantAt) {
+				t.Errorf("EligibleAt: got %v, want %v", got, test.wantAt)
+			}
+			if got := test.policy.Eligible(test.image); got != test.wantEligible {
+				t.Errorf("Eligible: got %v, want %v", got, test.wantEligible)
+			}
+		})
+	}
+}
+
+func TestNewRolloutPolicy(t *testing.T) {
+	policy, err := NewRolloutPolicy(EnvironmentMap{"UUPD_ROLLOUT_DELAY_HOURS": "12"})
+	if err != nil || policy.Wait() != 12*time.Hour {
+		t.Errorf("got %v, %v, want a 12h wait", policy.Wait(), err)
+	}
+
+	policy, err = NewRolloutPolicy(EnvironmentMap{"UUPD_ROLLOUT_DELAY_HOURS": "12", "UUPD_ROLLOUT_JITTER_HOURS": "6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Wait() < 12*time.Hour || policy.Wait() > 18*time.Hour {
+		t.Errorf("wait %v outside of the delay plus jitter", policy.Wait())
+	}
+	again, _ := NewRolloutPolicy(EnvironmentMap{"UUPD_ROLLOUT_DELAY_HOURS": "12", "UUPD_ROLLOUT_JITTER_HOURS": "6"})
+	if again.Wait() != policy.Wait() {
+		t.Errorf("wait changed between runs: %v, %v", policy.Wait(), again.Wait())
+	}
+
+	_, err = NewRolloutPolicy(EnvironmentMap{"UUPD_ROLLOUT_JITTER_HOURS": "soon"})
+	if err == nil {
+		t.Error("invalid jitter accepted")
+	}
+}
+
+func TestRolloutHold(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	build := func(digest string, age time.Duration) *ImageStatus {
+		return &ImageStatus{ImageDigest: digest, Timestamp: now.Add(-age).Format(time.RFC3339Nano)}
+	}
+	policy := RolloutPolicy{Delay: 48 * time.Hour}
+	tests := []struct {
+		name        string
+		state       RolloutState
+		update      *ImageStatus
+		wantAt      time.Time
+		wantChanged bool
+		wantDigest  string
+	}{
+		{
+			"first update held back",
+			RolloutState{},
+			build("sha256:b", 24*time.Hour), now.Add(24 * time.Hour), true, "sha256:b",
+		},
+		{
+			"newer build replaced it in the cache",
+			RolloutState{Booted: "sha256:a", Digest: "sha256:b", BuildTime: now.Add(-50 * time.Hour)},
+			build("sha256:c", 2*time.Hour), now.Add(-2 * time.Hour), false, "sha256:b",
+		},
+		{
+			"still waiting for the first one",
+			RolloutState{Booted: "sha256:a", Digest: "sha256:b", BuildTime: now.Add(-30 * time.Hour)},
+			build("sha256:c", 6*time.Hour), now.Add(18 * time.Hour), false, "sha256:b",
+		},
+		{
+			"booted into a new image",
+			RolloutState{Booted: "sha256:old", Digest: "sha256:b", BuildTime: now.Add(-100 * time.Hour)},
+			build("sha256:c", 6*time.Hour), now.Add(42 * time.Hour), true, "sha256:c",
+		},
+		{
+			"no build time",
+			RolloutState{},
+			&ImageStatus{ImageDigest: "sha256:b"}, time.Time{}, false, "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := test.state
+			at, changed := policy.Hold(&state, "sha256:a", test.update)
+			if !at.Equal(test.wantAt) || changed != test.wantChanged {
+				t.Errorf("got %v (changed %v), want %v (changed %v)", at, changed, test.wantAt, test.wantChanged)
+			}
+			if state.Digest != test.wantDigest {
+				t.Errorf("holding %s, want %s", state.Digest, test.wantDigest)
+			}
+		})
+	}
+}
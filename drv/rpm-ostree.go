@@ -8,11 +8,18 @@ import (
 	"os/exec"
 	"strings"
 	"time"
+
+	"github.com/ublue-os/uupd/pkg/session"
 )
 
 type rpmOstreeStatus struct {
 	Deployments []struct {
-		Timestamp int64 `json:"timestamp"`
+		Timestamp int64  `json:"timestamp"`
+		Version   string `json:"version"`
+		Booted    bool   `json:"booted"`
+		Staged    bool   `json:"staged"`
+		Checksum  string `json:"checksum"`
+		Image     string `json:"container-image-reference"`
 	} `json:"deployments"`
 }
 
@@ -21,43 +28,135 @@ type RpmOstreeUpdater struct {
 	BinaryPath string
 }
 
-func (dr RpmOstreeUpdater) Outdated() (bool, error) {
-	if dr.Config.DryRun {
-		return false, nil
+func (dr RpmOstreeUpdater) status() (rpmOstreeStatus, error) {
+	var status rpmOstreeStatus
+	cmd := exec.Command(dr.BinaryPath, "status", "--json")
+	out, err := cmd.Output()
+	if err != nil {
+		return status, err
 	}
-	oneMonthAgo := time.Now().AddDate(0, -1, 0)
-	var timestamp time.Time
+	err = json.Unmarshal(out, &status)
+	return status, err
+}
 
-	cmd := exec.Command(dr.BinaryPath, "status", "--json", "--booted")
-	out, err := cmd.CombinedOutput()
+// Compares the booted deployment with the pending one, nil if nothing is pending
+func (dr RpmOstreeUpdater) Changelog() (*Changelog, error) {
+	status, err := dr.status()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	var status rpmOstreeStatus
-	err = json.Unmarshal(out, &status)
+	var changelog Changelog
+	var pending bool
+	for i, deployment := range status.Deployments {
+		entry := Deployment{
+			Image:     deployment.Image,
+			Version:   deployment.Version,
+			Timestamp: time.Unix(deployment.Timestamp, 0).UTC().Format(time.RFC3339),
+			Checksum:  deployment.Checksum,
+		}
+		if deployment.Booted {
+			changelog.From = entry
+		} else if i == 0 {
+			// The first deployment is the default one, if it isn't booted it's pending
+			changelog.To = entry
+			pending = true
+		}
+	}
+	if !pending {
+		return nil, nil
+	}
+	err = changelog.comparePackages(dr.BinaryPath)
+	return &changelog, err
+}
+
+func (dr RpmOstreeUpdater) RebootPending() (bool, error) {
+	status, err := dr.status()
 	if err != nil {
 		return false, err
 	}
-	timestamp = time.Unix(status.Deployments[0].Timestamp, 0).UTC()
+	// The first deployment is the default one, if it isn't booted it's pending
+	return len(status.Deployments) > 0 && !status.Deployments[0].Booted, nil
+}
+
+func (dr RpmOstreeUpdater) Outdated(policy OutdatedPolicy) (OutdatedReport, error) {
+	if dr.Config.DryRun {
+		return OutdatedReport{}, nil
+	}
 
-	return timestamp.Before(oneMonthAgo), nil
+	status, err := dr.status()
+	if err != nil {
+		return OutdatedReport{}, err
+	}
+
+	var booted, staged *ImageAge
+	for _, deployment := range status.Deployments {
+		timestamp := time.Unix(deployment.Timestamp, 0).UTC()
+		if deployment.Booted {
+			age := newImageAge("booted", deployment.Version, timestamp, policy)
+			booted = &age
+		} else if deployment.Staged {
+			age := newImageAge("staged", deployment.Version, timestamp, policy)
+			staged = &age
+		}
+	}
+	return newOutdatedReport(booted, staged), nil
 }
 
 func (dr RpmOstreeUpdater) Update() (*[]CommandOutput, error) {
 	var finalOutput = []CommandOutput{}
-	var cmd *exec.Cmd
 	binaryPath := dr.BinaryPath
 	cli := []string{binaryPath, "upgrade"}
-	cmd = exec.Command(cli[0], cli[1:]...)
-	out, err := cmd.CombinedOutput()
+	out, err := session.RunScoped(cli)
 	tmpout := CommandOutput{}.New(out, err)
 	// tmpout.Cli = cli
 	tmpout.Failure = err != nil
 	tmpout.Context = "System Update"
+	tmpout.RebootRequired = err == nil
 	finalOutput = append(finalOutput, *tmpout)
 	return &finalOutput, err
 }
 
+func (dr RpmOstreeUpdater) Download() (*[]CommandOutput, error) {
+	var finalOutput = []CommandOutput{}
+	cli := []string{dr.BinaryPath, "upgrade", "--download-only"}
+	out, err := session.RunScoped(cli)
+	tmpout := CommandOutput{}.New(out, err)
+	tmpout.Cli = cli
+	tmpout.Failure = err != nil
+	tmpout.Context = "System download"
+	finalOutput = append(finalOutput, *tmpout)
+	if err != nil {
+		return &finalOutput, err
+	}
+	return &finalOutput, SavePhaseState(PhaseState{DownloadOnly: true, Time: time.Now()})
+}
+
+func (dr RpmOstreeUpdater) Apply() (*[]CommandOutput, error) {
+	var finalOutput = []CommandOutput{}
+
+	state, err := LoadPhaseState()
+	if err != nil || state == nil {
+		return &finalOutput, err
+	}
+
+	reboot := rebootOnApply(dr.Config.Environment)
+	cli := []string{dr.BinaryPath, "upgrade", "--cache-only"}
+	if reboot {
+		cli = append(cli, "--reboot")
+	}
+	out, err := session.RunScoped(cli)
+	tmpout := CommandOutput{}.New(out, err)
+	tmpout.Cli = cli
+	tmpout.Failure = err != nil
+	tmpout.Context = "System apply"
+	tmpout.RebootRequired = err == nil && !reboot
+	finalOutput = append(finalOutput, *tmpout)
+	if err != nil {
+		return &finalOutput, err
+	}
+	return &finalOutput, ClearPhaseState()
+}
+
 func (dr RpmOstreeUpdater) UpdateAvailable() (bool, error) {
 	// This function may or may not be accurate, rpm-ostree updgrade --check has issues... https://github.com/coreos/rpm-ostree/issues/1579
 	// Not worried because we will end up removing rpm-ostree from the equation soon
@@ -77,17 +176,11 @@ func (up RpmOstreeUpdater) Steps() int {
 }
 
 func BootcCompatible(binaryPath string) (bool, error) {
-	cmd := exec.Command(binaryPath, "status", "--format=json")
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return false, nil
-	}
-	var status bootcStatus
-	err = json.Unmarshal(out, &status)
+	status, err := BootcStatus(binaryPath)
 	if err != nil {
 		return false, nil
 	}
-	return !(status.Status.Booted.Incompatible || status.Status.Staged.Incompatible), nil
+	return !status.Incompatible(), nil
 }
 
 func (up RpmOstreeUpdater) New(config UpdaterInitConfiguration) (RpmOstreeUpdater, error) {
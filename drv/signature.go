@@ -0,0 +1,101 @@
+package drv
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Subset of containers-policy.json(5) needed to tell whether an image has to be signed
+type policyRequirement struct {
+	Type    string `json:"type"`
+	KeyPath string `json:"keyPath"`
+}
+
+type containersPolicy struct {
+	Default    []policyRequirement                       `json:"default"`
+	Transports map[string]map[string][]policyRequirement `json:"transports"`
+}
+
+// Image references without a tag or digest, from the most to the least specific scope.
+// e.g. ghcr.io/ublue-os/bluefin:stable -> ghcr.io/ublue-os/bluefin, ghcr.io/ublue-os, ghcr.io
+func policyScopes(ref string) []string {
+	scopes := []string{ref}
+	name := ref
+	if at := strings.Index(name, "@"); at != -1 {
+		name = name[:at]
+	} else if colon := strings.LastIndex(name, ":"); colon > strings.LastIndex(name, "/") {
+		name = name[:colon]
+	}
+	for name != "" {
+		scopes = append(scopes, name)
+		slash := strings.LastIndex(name, "/")
+		if slash == -1 {
+			break
+		}
+		name = name[:slash]
+	}
+	return scopes
+}
+
+// Returns the requirements containers/image would apply when pulling ref from a registry
+func (policy containersPolicy) requirementsFor(ref string) []policyRequirement {
+	docker := policy.Transports["docker"]
+	for _, scope := range policyScopes(ref) {
+		requirements, exists := docker[scope]
+		if exists {
+			return requirements
+		}
+	}
+	requirements, exists := docker[""]
+	if exists {
+		return requirements
+	}
+	return policy.Default
+}
+
+// Makes sure the containers policy requires a valid signature for ref, so that
+// `bootc switch --enforce-container-sigpolicy` actually verifies it.
+// If a cosign key is configured the signature is additionally checked with cosign upfront.
+func (dr SystemUpdater) VerifyImageSignature(ref string) (*CommandOutput, error) {
+	content, err := os.ReadFile(dr.policyPath)
+	if err != nil {
+		return nil, err
+	}
+	var policy containersPolicy
+	err = json.Unmarshal(content, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("Failed parsing %s: %v", dr.policyPath, err)
+	}
+
+	signed := false
+	for _, requirement := range policy.requirementsFor(ref) {
+		if requirement.Type != "sigstoreSigned" && requirement.Type != "signedBy" {
+			continue
+		}
+		if requirement.KeyPath != "" {
+			_, err := os.Stat(requirement.KeyPath)
+			if err != nil {
+				return nil, fmt.Errorf("Signing key for %s is missing: %v", ref, err)
+			}
+		}
+		signed = true
+	}
+	if !signed {
+		return nil, fmt.Errorf("%s does not require signatures for %s, refusing to switch", dr.policyPath, ref)
+	}
+
+	if dr.cosignKey == "" {
+		return nil, nil
+	}
+	cli := []string{dr.cosignPath, "verify", "--key", dr.cosignKey, ref}
+	cmd := exec.Command(cli[0], cli[1:]...)
+	out, err := cmd.CombinedOutput()
+	tmpout := CommandOutput{}.New(out, err)
+	tmpout.Context = "Signature verification for " + ref
+	tmpout.Cli = cli
+	tmpout.Failure = err != nil
+	return tmpout, err
+}
@@ -1,89 +1,362 @@
 package drv
 
 import (
-	"encoding/json"
+	"fmt"
 	"os/exec"
 	"strings"
 	"time"
-)
 
-type bootcStatus struct {
-	Status struct {
-		Booted struct {
-			Incompatible bool `json:"incompatible"`
-			Image        struct {
-				Timestamp string `json:"timestamp"`
-			} `json:"image"`
-		} `json:"booted"`
-		Staged struct {
-			Incompatible bool `json:"incompatible"`
-			Image        struct {
-				Timestamp string `json:"timestamp"`
-			} `json:"image"`
-		}
-	} `json:"status"`
-}
+	"github.com/ublue-os/uupd/pkg/session"
+)
 
 // Workaround interface to decouple individual drivers
 // (TODO: Remove this whenever rpm-ostree driver gets deprecated)
 type SystemUpdateDriver interface {
 	Steps() int
-	Outdated() (bool, error)
+	Outdated(policy OutdatedPolicy) (OutdatedReport, error)
 	UpdateAvailable() (bool, error)
 	Check() (bool, error)
 	Update() (*[]CommandOutput, error)
+	Download() (*[]CommandOutput, error)
+	Apply() (*[]CommandOutput, error)
+	Changelog() (*Changelog, error)
+	// Whether a deployment is staged for the next boot, whichever run staged it
+	RebootPending() (bool, error)
 }
 
 type SystemUpdater struct {
 	Config     DriverConfiguration
 	BinaryPath string
+	// Image the system should track, switched to on the next update when it differs
+	Target  string
+	channel string
+	// Local image source (oci-archive:, oci:, dir:...) or mirror, takes precedence over Target
+	Source   string
+	Registry RegistryConfiguration
+	Rollout  RolloutPolicy
+	// Loaded by the caller, so that it decides when to sync the remote blocklist
+	Blocklist     Blocklist
+	rpmOstreePath string
+	policyPath    string
+	cosignPath    string
+	cosignKey     string
 }
 
-func (dr SystemUpdater) Outdated() (bool, error) {
-	if dr.Config.DryRun {
-		return false, nil
+func (dr SystemUpdater) Status() (BootcHost, error) {
+	return BootcStatus(dr.BinaryPath)
+}
+
+// Replaces the tag of ref with channel, digest references are left alone
+func withChannel(ref string, channel string) string {
+	if strings.Contains(ref, "@") {
+		return ref
 	}
-	oneMonthAgo := time.Now().AddDate(0, -1, 0)
-	var timestamp time.Time
-	cmd := exec.Command(dr.BinaryPath, "status", "--format=json")
-	out, err := cmd.CombinedOutput()
+	colon := strings.LastIndex(ref, ":")
+	if colon > strings.LastIndex(ref, "/") {
+		ref = ref[:colon]
+	}
+	return ref + ":" + channel
+}
+
+// Returns the image the system should track: the local source, the configured target or channel,
+// or the current image. Mirrors don't show up here, they are configured for containers/image.
+func (dr SystemUpdater) desiredImage(current string) string {
+	target := dr.Source
+	if target == "" {
+		target = dr.Target
+	}
+	if target == "" && dr.channel != "" && current != "" && !ParseImageReference(current).Local() {
+		target = withChannel(current, dr.channel)
+	}
+	if target == "" {
+		target = current
+	}
+	if target == "" {
+		return ""
+	}
+
+	ref := ParseImageReference(target)
+	if ref.Local() {
+		return ref.String()
+	}
+	return ref.Image
+}
+
+// Returns the image currently tracked by bootc and the one uupd should switch to,
+// the target is empty when no switch is needed
+func (dr SystemUpdater) SwitchTarget() (string, string, error) {
+	status, err := dr.Status()
 	if err != nil {
-		return false, err
+		return "", "", err
+	}
+	current := status.SpecImage()
+	target := dr.desiredImage(current)
+	if target == current {
+		target = ""
+	}
+	return current, target, nil
+}
+
+// Whether system updates come from a local source and don't need the network
+func (dr SystemUpdater) Offline() bool {
+	return dr.Source != "" && ParseImageReference(dr.Source).Local()
+}
+
+// Switches the system to target after checking that its signature gets enforced
+func (dr SystemUpdater) Switch(target string) (*[]CommandOutput, error) {
+	var finalOutput = []CommandOutput{}
+
+	status, err := dr.Status()
+	if err != nil {
+		return &finalOutput, err
+	}
+	current := status.SpecImage()
+	context := "System switch from " + current + " to " + target
+
+	ref := ParseImageReference(target)
+	// Signatures can't be verified for local archives and directories
+	if !ref.Local() {
+		verifyOutput, err := dr.VerifyImageSignature(ref.Image)
+		if verifyOutput != nil {
+			finalOutput = append(finalOutput, *verifyOutput)
+		}
+		if err != nil {
+			tmpout := CommandOutput{}.New(nil, err)
+			tmpout.Stderr = err
+			tmpout.SetFailureContext(context)
+			finalOutput = append(finalOutput, *tmpout)
+			return &finalOutput, err
+		}
+	}
+
+	if dr.Config.DryRun {
+		return &finalOutput, nil
+	}
+
+	cli := []string{dr.BinaryPath, "switch", "--transport", ref.Transport}
+	if !ref.Local() {
+		cli = append(cli, "--enforce-container-sigpolicy")
 	}
-	var status bootcStatus
-	err = json.Unmarshal(out, &status)
+	cli = append(cli, ref.Image)
+	out, err := session.RunScoped(cli)
+	tmpout := CommandOutput{}.New(out, err)
+	tmpout.Context = context
+	tmpout.Cli = cli
+	tmpout.Failure = err != nil
+	tmpout.RebootRequired = err == nil
+	finalOutput = append(finalOutput, *tmpout)
+	return &finalOutput, err
+}
+
+func (dr SystemUpdater) RebootPending() (bool, error) {
+	status, err := dr.Status()
 	if err != nil {
 		return false, err
 	}
-	timestamp, err = time.Parse(time.RFC3339Nano, status.Status.Booted.Image.Timestamp)
+	return status.Status.Staged != nil, nil
+}
+
+func bootEntryAge(deployment string, entry *BootEntry, policy OutdatedPolicy) *ImageAge {
+	if entry == nil || entry.Image == nil {
+		return nil
+	}
+	timestamp, err := entry.Image.BuildTime()
+	if err != nil || timestamp.IsZero() {
+		return nil
+	}
+	age := newImageAge(deployment, entry.Image.Version, timestamp, policy)
+	return &age
+}
+
+func (dr SystemUpdater) Outdated(policy OutdatedPolicy) (OutdatedReport, error) {
+	if dr.Config.DryRun {
+		return OutdatedReport{}, nil
+	}
+	status, err := dr.Status()
 	if err != nil {
-		return false, nil
+		return OutdatedReport{}, err
 	}
-	return timestamp.Before(oneMonthAgo), nil
+	booted := bootEntryAge("booted", status.Status.Booted, policy)
+	staged := bootEntryAge("staged", status.Status.Staged, policy)
+	return newOutdatedReport(booted, staged), nil
 }
 
 func (dr SystemUpdater) Update() (*[]CommandOutput, error) {
 	var finalOutput = []CommandOutput{}
-	var cmd *exec.Cmd
+
+	source, target, err := dr.SwitchTarget()
+	if err != nil {
+		return &finalOutput, err
+	}
+	if target != "" {
+		return dr.Switch(target)
+	}
+
 	binaryPath := dr.BinaryPath
 	cli := []string{binaryPath, "upgrade"}
-	cmd = exec.Command(cli[0], cli[1:]...)
-	out, err := cmd.CombinedOutput()
+	out, err := session.RunScoped(cli)
 	tmpout := CommandOutput{}.New(out, err)
+	tmpout.Cli = cli
+	tmpout.Context = "System update from " + source
 	if err != nil {
-		tmpout.SetFailureContext("System update")
+		tmpout.SetFailureContext(tmpout.Context)
 	}
+	tmpout.RebootRequired = err == nil
 	finalOutput = append(finalOutput, *tmpout)
 	return &finalOutput, err
 }
 
-func (dr SystemUpdater) UpdateAvailable() (bool, error) {
-	cmd := exec.Command(dr.BinaryPath, "upgrade", "--check")
+// Older bootc releases don't know about --download-only/--from-downloaded
+func (dr SystemUpdater) upgradeSupports(flag string) bool {
+	cmd := exec.Command(dr.BinaryPath, "upgrade", "--help")
 	out, err := cmd.CombinedOutput()
+	return err == nil && strings.Contains(string(out), flag)
+}
+
+// Fetches the update without making it the default deployment when bootc supports it,
+// otherwise it is staged as usual and applied on the next reboot
+func (dr SystemUpdater) Download() (*[]CommandOutput, error) {
+	var finalOutput = []CommandOutput{}
+
+	current, target, err := dr.SwitchTarget()
+	if err != nil {
+		return &finalOutput, err
+	}
+
+	var out *[]CommandOutput
+	downloadOnly := false
+	if target != "" {
+		out, err = dr.Switch(target)
+		current = target
+	} else {
+		downloadOnly = dr.upgradeSupports("--download-only")
+		cli := []string{dr.BinaryPath, "upgrade"}
+		if downloadOnly {
+			cli = append(cli, "--download-only")
+		}
+		cmdOut, cmdErr := session.RunScoped(cli)
+		tmpout := CommandOutput{}.New(cmdOut, cmdErr)
+		tmpout.Context = "System download"
+		tmpout.Cli = cli
+		tmpout.Failure = cmdErr != nil
+		tmpout.RebootRequired = cmdErr == nil && !downloadOnly
+		out, err = &[]CommandOutput{*tmpout}, cmdErr
+	}
+	finalOutput = append(finalOutput, *out...)
+	if err != nil {
+		return &finalOutput, err
+	}
+
+	err = SavePhaseState(PhaseState{Image: current, DownloadOnly: downloadOnly, Time: time.Now()})
+	return &finalOutput, err
+}
+
+// Finalizes what the download phase fetched, rebooting into it if UUPD_REBOOT_POLICY=apply
+func (dr SystemUpdater) Apply() (*[]CommandOutput, error) {
+	var finalOutput = []CommandOutput{}
+
+	state, err := LoadPhaseState()
+	if err != nil || state == nil {
+		return &finalOutput, err
+	}
+
+	reboot := rebootOnApply(dr.Config.Environment)
+	var cli []string
+	if state.DownloadOnly {
+		cli = []string{dr.BinaryPath, "upgrade", "--from-downloaded"}
+	} else if reboot {
+		cli = []string{dr.BinaryPath, "upgrade"}
+	}
+	if reboot {
+		cli = append(cli, "--apply")
+	}
+
+	if cli != nil {
+		out, err := session.RunScoped(cli)
+		tmpout := CommandOutput{}.New(out, err)
+		tmpout.Context = "System apply"
+		tmpout.Cli = cli
+		tmpout.Failure = err != nil
+		tmpout.RebootRequired = err == nil && !reboot
+		finalOutput = append(finalOutput, *tmpout)
+		if err != nil {
+			return &finalOutput, err
+		}
+	}
+
+	return &finalOutput, ClearPhaseState()
+}
+
+// Compares the digest found by `bootc upgrade --check` with the booted and staged ones
+func (dr SystemUpdater) UpdateAvailable() (bool, error) {
+	update, err := dr.AvailableUpdate()
 	if err != nil {
 		return true, err
 	}
-	return !strings.Contains(string(out), "No changes in:"), nil
+	return update != nil, nil
+}
+
+// Refreshes bootc's cached update and returns it if it isn't deployed yet
+func (dr SystemUpdater) AvailableUpdate() (*ImageStatus, error) {
+	cmd := exec.Command(dr.BinaryPath, "upgrade", "--check")
+	_, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+	status, err := dr.Status()
+	if err != nil {
+		return nil, err
+	}
+	return status.AvailableUpdate(), nil
+}
+
+func imageDeployment(image *ImageStatus) Deployment {
+	if image == nil {
+		return Deployment{}
+	}
+	return Deployment{
+		Image:     image.Image.Image,
+		Version:   image.Version,
+		Timestamp: image.Timestamp,
+		Digest:    image.ImageDigest,
+	}
+}
+
+func bootEntryDeployment(entry *BootEntry) Deployment {
+	if entry == nil {
+		return Deployment{}
+	}
+	deployment := imageDeployment(entry.Image)
+	if entry.Ostree != nil {
+		deployment.Checksum = entry.Ostree.Checksum
+	}
+	return deployment
+}
+
+// Compares the booted deployment with the staged one, or with the available update if nothing is staged.
+// Returns nil if there is neither.
+func (dr SystemUpdater) Changelog() (*Changelog, error) {
+	status, err := dr.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status.Status.Booted == nil {
+		return nil, fmt.Errorf("No booted deployment found")
+	}
+	changelog := Changelog{From: bootEntryDeployment(status.Status.Booted)}
+
+	if status.Status.Staged != nil {
+		changelog.To = bootEntryDeployment(status.Status.Staged)
+		err = changelog.comparePackages(dr.rpmOstreePath)
+		return &changelog, err
+	}
+
+	update, err := dr.AvailableUpdate()
+	if err != nil || update == nil {
+		return nil, err
+	}
+	changelog.To = imageDeployment(update)
+	return &changelog, nil
 }
 
 func (up SystemUpdater) Steps() int {
@@ -113,6 +386,33 @@ func (up SystemUpdater) New(config UpdaterInitConfiguration) (SystemUpdater, err
 		up.BinaryPath = bootcBinaryPath
 	}
 
+	rpmOstreePath, exists := up.Config.Environment["UUPD_RPMOSTREE_BINARY"]
+	if !exists || rpmOstreePath == "" {
+		up.rpmOstreePath = "/usr/bin/rpm-ostree"
+	} else {
+		up.rpmOstreePath = rpmOstreePath
+	}
+
+	// Either a full image reference or a tag (stable, testing, gts...) of the tracked image
+	up.Target = up.Config.Environment["UUPD_BOOTC_TARGET"]
+	up.Source = up.Config.Environment["UUPD_SYSTEM_SOURCE"]
+	up.Registry = NewRegistryConfiguration(up.Config.Environment)
+	up.channel = up.Config.Environment["UUPD_BOOTC_CHANNEL"]
+
+	policyPath, exists := up.Config.Environment["UUPD_CONTAINERS_POLICY"]
+	if !exists || policyPath == "" {
+		up.policyPath = "/etc/containers/policy.json"
+	} else {
+		up.policyPath = policyPath
+	}
+	cosignPath, exists := up.Config.Environment["UUPD_COSIGN_BINARY"]
+	if !exists || cosignPath == "" {
+		up.cosignPath = "/usr/bin/cosign"
+	} else {
+		up.cosignPath = cosignPath
+	}
+	up.cosignKey = up.Config.Environment["UUPD_COSIGN_KEY"]
+
 	return up, nil
 }
 
@@ -121,5 +421,70 @@ func (up SystemUpdater) Check() (bool, error) {
 		return true, nil
 	}
 
-	return up.UpdateAvailable()
+	_, target, err := up.SwitchTarget()
+	if err != nil {
+		return false, err
+	}
+	if target != "" {
+		return true, nil
+	}
+
+	update, err := up.AvailableUpdate()
+	if err != nil {
+		return true, err
+	}
+	if update == nil {
+		return false, nil
+	}
+	health, err := LoadHealthState()
+	if err != nil {
+		return false, err
+	}
+	// Don't stage an image that failed its boot health check again
+	if health.IsBad(update.ImageDigest) || up.Blocklist.Blocks(update) {
+		return false, nil
+	}
+	eligibleAt, err := up.RolloutEligibleAt(update)
+	if err != nil {
+		return false, err
+	}
+	return !time.Now().Before(eligibleAt), nil
+}
+
+// When this machine may take update, see RolloutState
+func (up SystemUpdater) RolloutEligibleAt(update *ImageStatus) (time.Time, error) {
+	if up.Rollout.Wait() == 0 {
+		return time.Time{}, nil
+	}
+	status, err := up.Status()
+	if err != nil {
+		return up.Rollout.EligibleAt(update), err
+	}
+	state, err := LoadRolloutState()
+	if err != nil {
+		return up.Rollout.EligibleAt(update), err
+	}
+	eligibleAt, changed := up.Rollout.Hold(&state, status.Status.Booted.Digest(), update)
+	if changed {
+		err = SaveRolloutState(state)
+	}
+	return eligibleAt, err
+}
+
+// The update bootc found during the last check if the rollout policy holds it back,
+// along with when this machine becomes eligible for it
+func (up SystemUpdater) DeferredUpdate() (*ImageStatus, time.Time, error) {
+	status, err := up.Status()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	update := status.AvailableUpdate()
+	if update == nil {
+		return nil, time.Time{}, nil
+	}
+	eligibleAt, err := up.RolloutEligibleAt(update)
+	if err != nil || !time.Now().Before(eligibleAt) {
+		return nil, time.Time{}, err
+	}
+	return update, eligibleAt, nil
 }
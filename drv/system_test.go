@@ -0,0 +1,127 @@
+package drv
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseImageReference(t *testing.T) {
+	tests := []struct {
+		value     string
+		image     string
+		transport string
+		local     bool
+	}{
+		{"ghcr.io/ublue-os/bluefin:stable", "ghcr.io/ublue-os/bluefin:stable", "registry", false},
+		{"docker://ghcr.io/ublue-os/bluefin:stable", "ghcr.io/ublue-os/bluefin:stable", "registry", false},
+		{"registry:ghcr.io/ublue-os/bluefin:stable", "ghcr.io/ublue-os/bluefin:stable", "registry", false},
+		{"oci-archive:/run/media/usb/bluefin.tar", "/run/media/usb/bluefin.tar", "oci-archive", true},
+		{"oci:/var/cache/bluefin:stable", "/var/cache/bluefin:stable", "oci", true},
+		{"dir:/var/cache/bluefin", "/var/cache/bluefin", "dir", true},
+		{"containers-storage:localhost/bluefin", "localhost/bluefin", "containers-storage", true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			ref := ParseImageReference(test.value)
+			if ref.Image != test.image || ref.Transport != test.transport {
+				t.Fatalf("got %+v, want %s over %s", ref, test.image, test.transport)
+			}
+			if ref.Local() != test.local {
+				t.Errorf("Local() = %v", ref.Local())
+			}
+			// Registry references lose their prefix, the rest round-trip
+			if test.local && ref.String() != test.value {
+				t.Errorf("String() = %s", ref.String())
+			}
+			if !test.local && ref.String() != test.image {
+				t.Errorf("String() = %s", ref.String())
+			}
+		})
+	}
+}
+
+func TestWithChannel(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"ghcr.io/ublue-os/bluefin:stable", "ghcr.io/ublue-os/bluefin:testing"},
+		{"ghcr.io/ublue-os/bluefin", "ghcr.io/ublue-os/bluefin:testing"},
+		{"localhost:5000/bluefin", "localhost:5000/bluefin:testing"},
+		{"localhost:5000/bluefin:stable", "localhost:5000/bluefin:testing"},
+		{"ghcr.io/ublue-os/bluefin@sha256:abc", "ghcr.io/ublue-os/bluefin@sha256:abc"},
+	}
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			if got := withChannel(test.ref, "testing"); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDesiredImage(t *testing.T) {
+	const current = "ghcr.io/ublue-os/bluefin:stable"
+	tests := []struct {
+		name    string
+		updater SystemUpdater
+		current string
+		want    string
+	}{
+		{"current", SystemUpdater{}, current, current},
+		{"nothing booted", SystemUpdater{}, "", ""},
+		{"target", SystemUpdater{Target: "docker://ghcr.io/ublue-os/aurora:stable"}, current, "ghcr.io/ublue-os/aurora:stable"},
+		{"channel", SystemUpdater{channel: "testing"}, current, "ghcr.io/ublue-os/bluefin:testing"},
+		{"target over channel", SystemUpdater{Target: "ghcr.io/ublue-os/aurora:stable", channel: "testing"}, current, "ghcr.io/ublue-os/aurora:stable"},
+		{"local source over target", SystemUpdater{Source: "oci-archive:/run/media/usb/bluefin.tar", Target: "ghcr.io/ublue-os/aurora:stable"}, current, "oci-archive:/run/media/usb/bluefin.tar"},
+		{"registry source", SystemUpdater{Source: "registry:mirror.local/ublue-os/bluefin:stable"}, current, "mirror.local/ublue-os/bluefin:stable"},
+		{"channel ignored for a local image", SystemUpdater{channel: "testing"}, "oci:/var/cache/bluefin", "oci:/var/cache/bluefin"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.updater.desiredImage(test.current); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOffline(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"", false},
+		{"ghcr.io/ublue-os/bluefin:stable", false},
+		{"oci-archive:/run/media/usb/bluefin.tar", true},
+		{"dir:/var/cache/bluefin", true},
+	}
+	for _, test := range tests {
+		if got := (SystemUpdater{Source: test.source}).Offline(); got != test.want {
+			t.Errorf("Offline() with source %q = %v", test.source, got)
+		}
+	}
+}
+
+func TestFlatpakSideloadRepos(t *testing.T) {
+	tests := []struct {
+		repos string
+		want  []string
+	}{
+		{"", []string{"/usr/bin/flatpak", "update", "-y"}},
+		{"/run/media/usb/.ostree/repo", []string{"/usr/bin/flatpak", "update", "-y", "--sideload-repo=/run/media/usb/.ostree/repo"}},
+		{"/mnt/a, /mnt/b", []string{"/usr/bin/flatpak", "update", "-y", "--sideload-repo=/mnt/a", "--sideload-repo=/mnt/b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.repos, func(t *testing.T) {
+			env := map[string]string{"UUPD_FLATPAK_SIDELOAD_REPOS": test.repos}
+			updater, err := FlatpakUpdater{}.New(UpdaterInitConfiguration{Environment: env})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := updater.updateCli(); !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
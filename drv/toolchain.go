@@ -0,0 +1,168 @@
+package drv
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ublue-os/uupd/pkg/percent"
+	"github.com/ublue-os/uupd/pkg/session"
+)
+
+type toolchain struct {
+	// Binary that has to be on the user's PATH for the toolchain to be updated
+	probe   string
+	command string
+}
+
+// User-level toolchains that can be opted into with UUPD_TOOLCHAINS
+var toolchains = map[string]toolchain{
+	"rustup": {probe: "rustup", command: "rustup update"},
+	"pipx":   {probe: "pipx", command: "pipx upgrade-all"},
+	"cargo":  {probe: "cargo-install-update", command: "cargo install-update --all"},
+	"npm":    {probe: "npm", command: "npm update --global"},
+	"pnpm":   {probe: "pnpm", command: "pnpm update --global"},
+}
+
+type toolchainSection struct {
+	user session.User
+	name string
+}
+
+// Opt-in driver for per-user toolchains (rustup, pipx, cargo install-update, npm and pnpm globals).
+// UUPD_TOOLCHAINS lists the toolchains updated for every user,
+// UUPD_TOOLCHAINS_<uid> overrides that list for a single user ("none" disables it).
+// Commands run through the user's login shell so that their PATH is honoured.
+type ToolchainUpdater struct {
+	Config       DriverConfiguration
+	Tracker      *TrackerConfiguration
+	shellPath    string
+	sections     []toolchainSection
+	users        []session.User
+	usersEnabled bool
+}
+
+func (up ToolchainUpdater) Steps() int {
+	if up.Config.Enabled {
+		return len(up.sections)
+	}
+	return 0
+}
+
+func parseToolchains(value string) ([]string, error) {
+	var names []string
+	if value == "none" {
+		return names, nil
+	}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.Contains(names, name) {
+			continue
+		}
+		if _, exists := toolchains[name]; !exists {
+			return nil, fmt.Errorf("Unknown toolchain: %s", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (up ToolchainUpdater) New(config UpdaterInitConfiguration) (ToolchainUpdater, error) {
+	userdesc := "for User:"
+	up.Config = DriverConfiguration{
+		Title:           "Toolchains",
+		Description:     "Developer Toolchains",
+		UserDescription: &userdesc,
+		Enabled:         true,
+		MultiUser:       true,
+		DryRun:          config.DryRun,
+		Environment:     config.Environment,
+	}
+	up.usersEnabled = false
+	up.Tracker = nil
+
+	shellPath, exists := up.Config.Environment["UUPD_TOOLCHAIN_SHELL"]
+	if !exists || shellPath == "" {
+		up.shellPath = "/bin/bash"
+	} else {
+		up.shellPath = shellPath
+	}
+
+	// Validate every toolchain list upfront so SetUsers doesn't have to
+	for key, value := range up.Config.Environment {
+		if !strings.HasPrefix(key, "UUPD_TOOLCHAINS") {
+			continue
+		}
+		_, err := parseToolchains(value)
+		if err != nil {
+			return up, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+
+	return up, nil
+}
+
+// Resolves which toolchains are enabled for every user, the driver disables itself if there are none
+func (up *ToolchainUpdater) SetUsers(users []session.User) {
+	up.users = users
+	up.usersEnabled = true
+	up.sections = []toolchainSection{}
+
+	defaults, _ := parseToolchains(up.Config.Environment["UUPD_TOOLCHAINS"])
+	for _, user := range users {
+		names := defaults
+		override, exists := up.Config.Environment["UUPD_TOOLCHAINS_"+strconv.Itoa(user.UID)]
+		if exists && override != "" {
+			names, _ = parseToolchains(override)
+		}
+		for _, name := range names {
+			up.sections = append(up.sections, toolchainSection{user: user, name: name})
+		}
+	}
+
+	if len(up.sections) == 0 {
+		up.Config.Enabled = false
+	}
+}
+
+func (up ToolchainUpdater) Check() (*[]CommandOutput, error) {
+	return nil, nil
+}
+
+func (up ToolchainUpdater) updateSection(section toolchainSection, context string) []CommandOutput {
+	var outputs = []CommandOutput{}
+	tool := toolchains[section.name]
+
+	_, err := session.RunUID(section.user.UID, []string{up.shellPath, "-lc", "command -v " + tool.probe}, nil)
+	if err != nil {
+		// Not installed for this user
+		return outputs
+	}
+
+	cli := []string{up.shellPath, "-lc", tool.command}
+	out, err := session.RunUID(section.user.UID, cli, nil)
+	tmpout := CommandOutput{}.New(out, err)
+	tmpout.Context = context
+	tmpout.Cli = cli
+	tmpout.Failure = err != nil
+	return append(outputs, *tmpout)
+}
+
+func (up *ToolchainUpdater) Update() (*[]CommandOutput, error) {
+	var finalOutput = []CommandOutput{}
+
+	for i, section := range up.sections {
+		// The caller increments the tracker after the last section
+		if i > 0 {
+			up.Tracker.Tracker.IncrementSection(nil)
+		}
+		context := section.name + " " + *up.Config.UserDescription + " " + section.user.Name
+		percent.ChangeTrackerMessageFancy(*up.Tracker.Writer, up.Tracker.Tracker, up.Tracker.Progress, percent.TrackerMessage{Title: up.Config.Title, Description: context})
+		if up.Config.DryRun {
+			continue
+		}
+		finalOutput = append(finalOutput, tagUser(up.updateSection(section, context), section.user.Name)...)
+	}
+	return &finalOutput, nil
+}
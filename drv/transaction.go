@@ -0,0 +1,49 @@
+package drv
+
+import (
+	"os"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/ublue-os/uupd/pkg/filelock"
+)
+
+// Held by bootc and rpm-ostree while they change deployments
+const ostreeSysrootLock = "/sysroot/ostree/lock"
+
+func SysrootLocked() bool {
+	file, err := os.Open(ostreeSysrootLock)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return filelock.IsFileLocked(file)
+}
+
+// Returns the action (upgrade, deploy, rollback...) of the transaction rpm-ostreed is running,
+// empty if there is none. rpm-ostreed is not started just to ask.
+func RpmOstreeTransaction() (string, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var running bool
+	err = conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, "org.projectatomic.rpmostree1").Store(&running)
+	if err != nil || !running {
+		return "", err
+	}
+
+	sysroot := conn.Object("org.projectatomic.rpmostree1", "/org/projectatomic/rpmostree1/Sysroot")
+	property, err := sysroot.GetProperty("org.projectatomic.rpmostree1.Sysroot.ActiveTransaction")
+	if err != nil {
+		return "", err
+	}
+	// (action, sender, object path), all empty when idle
+	transaction, ok := property.Value().([]interface{})
+	if !ok || len(transaction) == 0 {
+		return "", nil
+	}
+	action, _ := transaction[0].(string)
+	return action, nil
+}
@@ -1,15 +1,67 @@
 package filelock
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
 
 const fileLockPath string = "/run/uupd.lock"
 
+// Who holds the lock, written into the lock file once it is acquired
+type Owner struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Command string    `json:"command"`
+	// systemd unit the owner runs in, empty when started from a terminal
+	Unit string `json:"unit,omitempty"`
+}
+
+func (owner Owner) String() string {
+	by := owner.Unit
+	if by == "" {
+		by = owner.Command
+	}
+	return fmt.Sprintf("uupd (pid %d, started %s by %s)", owner.PID, owner.Started.Local().Format("15:04"), by)
+}
+
+// Whether the owner's process still exists
+func (owner Owner) Alive() bool {
+	if owner.PID <= 0 {
+		return false
+	}
+	err := syscall.Kill(owner.PID, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+type LockedError struct {
+	// nil if the lock file doesn't say who holds it
+	Owner *Owner
+}
+
+func (err LockedError) Error() string {
+	if err.Owner == nil {
+		return fmt.Sprintf("Another uupd process holds %s", fileLockPath)
+	}
+	if !err.Owner.Alive() {
+		// flock is dropped when a process exits, so someone inherited the lock from it
+		return fmt.Sprintf("%s holds %s but has exited, its lock got inherited by another process", err.Owner, fileLockPath)
+	}
+	return fmt.Sprintf("%s is running", err.Owner)
+}
+
+type Lock struct {
+	file *os.File
+	// Owner that left the lock file behind without releasing it, if any
+	Stale *Owner
+}
+
 func IsFileLocked(file *os.File) bool {
 	lock := syscall.Flock_t{
 		Type:   syscall.F_WRLCK,
@@ -22,34 +74,102 @@ func IsFileLocked(file *os.File) bool {
 	return lock.Type != syscall.F_UNLCK
 }
 
-func AcquireLock() (*os.File, error) {
-	file, err := os.OpenFile(fileLockPath, os.O_RDONLY|os.O_CREATE|os.O_TRUNC, 0600)
+// systemd unit of the current process from its cgroup, e.g. uupd.service
+func currentUnit() string {
+	content, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		unit := filepath.Base(line)
+		if strings.HasSuffix(unit, ".service") {
+			return unit
+		}
+	}
+	return ""
+}
+
+func readOwner(file *os.File) *Owner {
+	content, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<16))
+	if err != nil || len(content) == 0 {
+		return nil
+	}
+	var owner Owner
+	err = json.Unmarshal(content, &owner)
+	if err != nil {
+		return nil
+	}
+	return &owner
+}
+
+// Reports whether the lock is held and by whom, the owner is nil if the lock file doesn't say.
+// The lock file is only readable by root, since it records the owner's command line.
+func CurrentOwner() (*Owner, bool, error) {
+	file, err := os.Open(fileLockPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		return nil, false, nil
+	}
+	return readOwner(file), true, nil
+}
+
+// Takes the uupd lock, waiting up to timeout for another process to release it.
+// A timeout of 0 fails right away, a negative one waits forever.
+// Fails with a LockedError when somebody else holds the lock.
+func AcquireLock(timeout time.Duration) (*Lock, error) {
+	file, err := os.OpenFile(fileLockPath, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return nil, err
 	}
 
-	timeout := 5.0
 	startTime := time.Now()
-	var lockFile *os.File
-
-	for time.Since(startTime).Seconds() < timeout {
+	for {
 		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
 		if err == nil {
-			lockFile = file
 			break
 		}
-
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			file.Close()
+			return nil, err
+		}
+		if timeout >= 0 && time.Since(startTime) >= timeout {
+			owner := readOwner(file)
+			file.Close()
+			return nil, LockedError{Owner: owner}
+		}
 		time.Sleep(1 * time.Second)
 	}
 
-	if lockFile == nil {
-		file.Close()
-		return nil, fmt.Errorf("Could not acquire lock at %s", fileLockPath)
-	}
+	lock := &Lock{file: file}
+	// Only a process that died without releasing leaves its owner info behind
+	lock.Stale = readOwner(file)
 
-	return lockFile, nil
+	content, err := json.Marshal(Owner{PID: os.Getpid(), Started: time.Now(), Command: strings.Join(os.Args, " "), Unit: currentUnit()})
+	if err == nil {
+		err = file.Truncate(0)
+	}
+	if err == nil {
+		_, err = file.WriteAt(content, 0)
+	}
+	if err != nil {
+		_ = lock.Release()
+		return nil, err
+	}
+	return lock, nil
 }
 
-func ReleaseLock(file *os.File) error {
-	return syscall.Close(int(file.Fd()))
+// Clears the owner info and drops the lock
+func (lock *Lock) Release() error {
+	truncErr := lock.file.Truncate(0)
+	unlockErr := syscall.Flock(int(lock.file.Fd()), syscall.LOCK_UN)
+	closeErr := lock.file.Close()
+	return errors.Join(truncErr, unlockErr, closeErr)
 }
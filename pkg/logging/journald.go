@@ -0,0 +1,183 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/sys/unix"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// Sends records to journald with the native protocol, attributes become UUPD_<KEY> fields
+// and every record carries UUPD_RUN_ID, so that one run can be told apart from the next.
+type JournalHandler struct {
+	conn   *net.UnixConn
+	level  slog.Leveler
+	attrs  []slog.Attr
+	groups []string
+	runID  string
+	// Records too big for a datagram end up here, stderr is the journal stream anyway
+	fallback slog.Handler
+	m        *sync.Mutex
+}
+
+// systemd sets JOURNAL_STREAM to the device and inode of the stream connected to the journal.
+// Child processes inherit it even when their stderr goes elsewhere, so it has to match stderr.
+func JournalAvailable() bool {
+	device, inode, found := strings.Cut(os.Getenv("JOURNAL_STREAM"), ":")
+	if !found {
+		return false
+	}
+	dev, err := strconv.ParseUint(device, 10, 64)
+	if err != nil {
+		return false
+	}
+	ino, err := strconv.ParseUint(inode, 10, 64)
+	if err != nil {
+		return false
+	}
+	var stat unix.Stat_t
+	err = unix.Fstat(int(os.Stderr.Fd()), &stat)
+	return err == nil && uint64(stat.Dev) == dev && uint64(stat.Ino) == ino
+}
+
+func NewJournalHandler(opts *slog.HandlerOptions) (*JournalHandler, error) {
+	if opts == nil {
+		opts = &slog.HandlerOptions{}
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &JournalHandler{
+		conn:     conn,
+		level:    opts.Level,
+		runID:    hex.EncodeToString(id),
+		fallback: slog.NewTextHandler(os.Stderr, opts),
+		m:        &sync.Mutex{},
+	}, nil
+}
+
+// slog levels to syslog priorities
+func journalPriority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3"
+	case level >= slog.LevelWarn:
+		return "4"
+	case level >= slog.LevelInfo:
+		return "6"
+	}
+	return "7"
+}
+
+// Journal field names only allow uppercase letters, digits and underscores
+func journalFieldName(groups []string, key string) string {
+	name := strings.Join(append(append([]string{"UUPD"}, groups...), key), "_")
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+func writeJournalField(b *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	// Multi-line values are sent as the name, a little-endian length and the raw value
+	b.WriteString(name + "\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+func writeJournalAttr(b *bytes.Buffer, groups []string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			groups = append(groups, attr.Key)
+		}
+		for _, child := range value.Group() {
+			writeJournalAttr(b, groups, child)
+		}
+		return
+	}
+	if attr.Key == "" {
+		return
+	}
+	writeJournalField(b, journalFieldName(groups, attr.Key), value.String())
+}
+
+func (h *JournalHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.level != nil {
+		minLevel = h.level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *JournalHandler) Handle(ctx context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", r.Message)
+	writeJournalField(&b, "PRIORITY", journalPriority(r.Level))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", "uupd")
+	writeJournalField(&b, "UUPD_RUN_ID", h.runID)
+	for _, attr := range h.attrs {
+		writeJournalAttr(&b, nil, attr)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		writeJournalAttr(&b, h.groups, attr)
+		return true
+	})
+
+	h.m.Lock()
+	_, err := h.conn.Write(b.Bytes())
+	h.m.Unlock()
+	if err != nil {
+		return h.fallback.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (h *JournalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler := *h
+	handler.attrs = append([]slog.Attr{}, h.attrs...)
+	// Attributes are prefixed with the groups opened before them
+	for _, attr := range attrs {
+		for i := len(h.groups) - 1; i >= 0; i-- {
+			attr = slog.Group(h.groups[i], attr)
+		}
+		handler.attrs = append(handler.attrs, attr)
+	}
+	handler.fallback = h.fallback.WithAttrs(attrs)
+	return &handler
+}
+
+func (h *JournalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	handler := *h
+	handler.groups = append(append([]string{}, h.groups...), name)
+	handler.fallback = h.fallback.WithGroup(name)
+	return &handler
+}
@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestJournalFieldName(t *testing.T) {
+	tests := []struct {
+		groups []string
+		key    string
+		want   string
+	}{
+		{nil, "driver", "UUPD_DRIVER"},
+		{nil, "exit-code", "UUPD_EXIT_CODE"},
+		{[]string{"output"}, "stdout", "UUPD_OUTPUT_STDOUT"},
+		{nil, "naïve key", "UUPD_NA_VE_KEY"},
+		{nil, "cpu2", "UUPD_CPU2"},
+	}
+	for _, test := range tests {
+		if got := journalFieldName(test.groups, test.key); got != test.want {
+			t.Errorf("journalFieldName(%q, %q) = %s, want %s", test.groups, test.key, got, test.want)
+		}
+	}
+}
+
+func TestWriteJournalField(t *testing.T) {
+	length := func(value string) string {
+		var b bytes.Buffer
+		_ = binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+		return b.String()
+	}
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"single line", "Flatpak Update", "MESSAGE=Flatpak Update\n"},
+		{"empty", "", "MESSAGE=\n"},
+		{"multiple lines", "error: one\nerror: two", "MESSAGE\n" + length("error: one\nerror: two") + "error: one\nerror: two\n"},
+		{"trailing newline", "done\n", "MESSAGE\n" + length("done\n") + "done\n\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b bytes.Buffer
+			writeJournalField(&b, "MESSAGE", test.value)
+			if got := b.String(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestJournalPriority(t *testing.T) {
+	for level, want := range map[slog.Level]string{slog.LevelDebug: "7", slog.LevelInfo: "6", slog.LevelWarn: "4", slog.LevelError: "3", slog.LevelError + 4: "3"} {
+		if got := journalPriority(level); got != want {
+			t.Errorf("journalPriority(%s) = %s, want %s", level, got, want)
+		}
+	}
+}
+
+// Handler writing to a datagram socket standing in for journald
+func journalStandIn(t *testing.T) (*JournalHandler, *net.UnixConn) {
+	t.Helper()
+	addr := &net.UnixAddr{Name: filepath.Join(t.TempDir(), "socket"), Net: "unixgram"}
+	server, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	handler := &JournalHandler{conn: conn, runID: "run", fallback: slog.NewTextHandler(os.Stderr, nil), m: &sync.Mutex{}}
+	return handler, server
+}
+
+func TestJournalHandler(t *testing.T) {
+	handler, server := journalStandIn(t)
+	logger := slog.New(handler).With(slog.String("driver", "flatpak")).WithGroup("output")
+	logger.Warn("Flatpak Update failed", slog.String("stderr", "error: one\nerror: two"), slog.Int("code", 1))
+
+	err := server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	datagram := make([]byte, 4096)
+	n, err := server.Read(datagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(datagram[:n])
+	for _, want := range []string{
+		"MESSAGE=Flatpak Update failed\n",
+		"PRIORITY=4\n",
+		"SYSLOG_IDENTIFIER=uupd\n",
+		"UUPD_RUN_ID=run\n",
+		"UUPD_DRIVER=flatpak\n",
+		"UUPD_OUTPUT_STDERR\n",
+		"error: one\nerror: two\n",
+		"UUPD_OUTPUT_CODE=1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in %q", want, got)
+		}
+	}
+
+	if handler.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("debug records enabled by default")
+	}
+}
+
+func TestJournalAvailable(t *testing.T) {
+	var stat unix.Stat_t
+	err := unix.Fstat(int(os.Stderr.Fd()), &stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		stream string
+		want   bool
+	}{
+		{"", false},
+		{fmt.Sprintf("%d:%d", stat.Dev, stat.Ino), true},
+		{fmt.Sprintf("%d:%d", stat.Dev, stat.Ino+1), false},
+		{fmt.Sprintf("%d", stat.Dev), false},
+		{"a:b", false},
+	}
+	for _, test := range tests {
+		t.Setenv("JOURNAL_STREAM", test.stream)
+		if got := JournalAvailable(); got != test.want {
+			t.Errorf("JOURNAL_STREAM=%q: got %v, want %v", test.stream, got, test.want)
+		}
+	}
+}
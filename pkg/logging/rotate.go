@@ -0,0 +1,167 @@
+package logging
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// When to rotate a log file and what to keep, zero values disable that limit
+type RotationOptions struct {
+	MaxSize int64
+	MaxAge  time.Duration
+	// Rotated files to keep, as path.1, path.2...
+	Keep     int
+	Compress bool
+	Mode     os.FileMode
+}
+
+// Log file that moves itself out of the way once it gets too big or too old
+type RotatingFile struct {
+	path    string
+	opts    RotationOptions
+	file    *os.File
+	size    int64
+	created time.Time
+	m       sync.Mutex
+}
+
+func OpenRotatingFile(path string, opts RotationOptions) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, opts: opts}
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Birth time of the file where the filesystem records it, modification time otherwise
+func fileCreated(path string, info os.FileInfo) time.Time {
+	var stx unix.Statx_t
+	err := unix.Statx(unix.AT_FDCWD, path, 0, unix.STATX_BTIME, &stx)
+	if err == nil && stx.Mask&unix.STATX_BTIME != 0 {
+		return time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec))
+	}
+	return info.ModTime()
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, rf.opts.Mode)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	// OpenFile only applies the mode to new files
+	if info.Mode().Perm() != rf.opts.Mode.Perm() {
+		err = file.Chmod(rf.opts.Mode)
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	rf.file = file
+	rf.size = info.Size()
+	rf.created = fileCreated(rf.path, info)
+	return nil
+}
+
+func (rf *RotatingFile) shouldRotate(incoming int) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.opts.MaxSize > 0 && rf.size+int64(incoming) > rf.opts.MaxSize {
+		return true
+	}
+	return rf.opts.MaxAge > 0 && time.Since(rf.created) > rf.opts.MaxAge
+}
+
+func (rf *RotatingFile) rotatedName(index int) string {
+	name := fmt.Sprintf("%s.%d", rf.path, index)
+	if rf.opts.Compress {
+		name += ".gz"
+	}
+	return name
+}
+
+func compressFile(source string, destination string, mode os.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(out)
+	_, err = io.Copy(writer, in)
+	err = errors.Join(err, writer.Close(), out.Close())
+	if err != nil {
+		return err
+	}
+	return os.Remove(source)
+}
+
+// Shifts path.N to path.N+1, dropping what exceeds Keep, and starts a new file
+func (rf *RotatingFile) rotate() error {
+	err := rf.file.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(rf.rotatedName(rf.opts.Keep))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := rf.opts.Keep - 1; i >= 1; i-- {
+		err = os.Rename(rf.rotatedName(i), rf.rotatedName(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if rf.opts.Keep < 1 {
+		err = os.Remove(rf.path)
+	} else if rf.opts.Compress {
+		err = compressFile(rf.path, rf.rotatedName(1), rf.opts.Mode)
+	} else {
+		err = os.Rename(rf.path, rf.rotatedName(1))
+	}
+	if err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.m.Lock()
+	defer rf.m.Unlock()
+	if rf.shouldRotate(len(p)) {
+		err := rf.rotate()
+		if err != nil {
+			// Keep logging to the unrotated file rather than losing entries
+			reopenErr := rf.open()
+			if reopenErr != nil {
+				return 0, errors.Join(err, reopenErr)
+			}
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.m.Lock()
+	defer rf.m.Unlock()
+	return rf.file.Close()
+}
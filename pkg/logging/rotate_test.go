@@ -0,0 +1,154 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if filepath.Ext(path) == ".gz" {
+		reader, err = gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestRotatingFileBySize(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RotationOptions
+		// Expected contents of each file after writing "1\n" to "5\n"
+		want map[string]string
+	}{
+		{
+			"keep two",
+			RotationOptions{MaxSize: 4, Keep: 2, Mode: 0640},
+			map[string]string{"uupd.log": "5\n", "uupd.log.1": "3\n4\n", "uupd.log.2": "1\n2\n"},
+		},
+		{
+			"keep two compressed",
+			RotationOptions{MaxSize: 4, Keep: 2, Compress: true, Mode: 0640},
+			map[string]string{"uupd.log": "5\n", "uupd.log.1.gz": "3\n4\n", "uupd.log.2.gz": "1\n2\n"},
+		},
+		{
+			"keep none",
+			RotationOptions{MaxSize: 4, Mode: 0600},
+			map[string]string{"uupd.log": "5\n"},
+		},
+		{
+			"no limit",
+			RotationOptions{Keep: 2, Mode: 0600},
+			map[string]string{"uupd.log": "1\n2\n3\n4\n5\n"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rf, err := OpenRotatingFile(filepath.Join(dir, "uupd.log"), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range []string{"1\n", "2\n", "3\n", "4\n", "5\n"} {
+				_, err = rf.Write([]byte(line))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = rf.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(test.want) {
+				t.Errorf("got %d files, want %d", len(entries), len(test.want))
+			}
+			for name, want := range test.want {
+				path := filepath.Join(dir, name)
+				if got := readLog(t, path); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Perm() != test.opts.Mode {
+					t.Errorf("%s: mode %v, want %v", name, info.Mode().Perm(), test.opts.Mode)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFileByAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "uupd.log")
+	rf, err := OpenRotatingFile(path, RotationOptions{MaxAge: time.Hour, Keep: 1, Mode: 0640})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	_, err = rf.Write([]byte("old\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rf.Write([]byte("recent\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readLog(t, path); got != "old\nrecent\n" {
+		t.Fatalf("rotated a new file: %q", got)
+	}
+
+	rf.created = time.Now().Add(-2 * time.Hour)
+	_, err = rf.Write([]byte("new\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readLog(t, path); got != "new\n" {
+		t.Errorf("got %q, want %q", got, "new\n")
+	}
+	if got := readLog(t, path+".1"); got != "old\nrecent\n" {
+		t.Errorf("rotated file: got %q", got)
+	}
+}
+
+func TestRotatingFileFixesMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uupd.log")
+	err := os.WriteFile(path, []byte("existing\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf, err := OpenRotatingFile(path, RotationOptions{Mode: 0640})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode %v, want 0640", info.Mode().Perm())
+	}
+}
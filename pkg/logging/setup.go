@@ -1,10 +1,14 @@
 package logging
 
 import (
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"slices"
 	"strings"
+
+	"golang.org/x/term"
 )
 
 func StrToLogLevel(logLevel string) (slog.Leveler, error) {
@@ -26,11 +30,54 @@ func StrToLogLevel(logLevel string) (slog.Leveler, error) {
 	return logLevels[logLevel], nil
 }
 
-func SetupAppLogger(writer *os.File, logLevel slog.Leveler, verbose bool) slog.Handler {
-	if verbose {
+const (
+	// JSON for log files, journald under systemd and pretty otherwise
+	FormatAuto    = "auto"
+	FormatJSON    = "json"
+	FormatPretty  = "pretty"
+	FormatCompact = "compact"
+)
+
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatAuto, FormatJSON, FormatPretty, FormatCompact:
+		return nil
+	}
+	return fmt.Errorf("Invalid log format: %s, expected %s, %s, %s or %s", format, FormatAuto, FormatJSON, FormatPretty, FormatCompact)
+}
+
+// Resolves --color=auto|always|never, auto colors terminals unless NO_COLOR is set
+func UseColor(mode string, writer io.Writer) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		file, isFile := writer.(*os.File)
+		return os.Getenv("NO_COLOR") == "" && isFile && term.IsTerminal(int(file.Fd())), nil
+	}
+	return false, fmt.Errorf("Invalid color mode: %s, expected auto, always or never", mode)
+}
+
+func SetupAppLogger(writer io.Writer, logLevel slog.Leveler, format string, color bool) slog.Handler {
+	switch format {
+	case FormatJSON:
 		return slog.NewJSONHandler(writer, &slog.HandlerOptions{
 			Level: logLevel,
 		})
+	case FormatCompact:
+		return NewUserHandler(writer, &UserHandlerOptions{HandlerOptions: slog.HandlerOptions{Level: logLevel}, Color: color, Compact: true})
+	case FormatAuto:
+		if writer != io.Writer(os.Stdout) {
+			return SetupAppLogger(writer, logLevel, FormatJSON, color)
+		}
+		if JournalAvailable() {
+			handler, err := NewJournalHandler(&slog.HandlerOptions{Level: logLevel})
+			if err == nil {
+				return handler
+			}
+		}
 	}
-	return NewUserHandler(&slog.HandlerOptions{Level: logLevel})
+	return NewUserHandler(writer, &UserHandlerOptions{HandlerOptions: slog.HandlerOptions{Level: logLevel}, Color: color})
 }
@@ -5,7 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -57,14 +59,27 @@ func (h *UserHandler) computeAttrs(
 	return attrs, nil
 }
 
-func colorize(colorCode int, v string) string {
+func (h *UserHandler) colorize(colorCode int, v string) string {
+	if !h.color {
+		return v
+	}
 	return fmt.Sprintf("\033[%sm%s%s", strconv.Itoa(colorCode), v, reset)
 }
 
+type UserHandlerOptions struct {
+	slog.HandlerOptions
+	Color bool
+	// Attributes as key=value on the same line instead of a YAML block below the message
+	Compact bool
+}
+
 type UserHandler struct {
-	h slog.Handler
-	b *bytes.Buffer
-	m *sync.Mutex
+	h       slog.Handler
+	b       *bytes.Buffer
+	m       *sync.Mutex
+	w       io.Writer
+	color   bool
+	compact bool
 }
 
 func (h *UserHandler) Handle(ctx context.Context, r slog.Record) error {
@@ -72,13 +87,13 @@ func (h *UserHandler) Handle(ctx context.Context, r slog.Record) error {
 
 	switch r.Level {
 	case slog.LevelDebug:
-		level = colorize(darkGray, level)
+		level = h.colorize(darkGray, level)
 	case slog.LevelInfo:
-		level = colorize(cyan, level)
+		level = h.colorize(cyan, level)
 	case slog.LevelWarn:
-		level = colorize(lightYellow, level)
+		level = h.colorize(lightYellow, level)
 	case slog.LevelError:
-		level = colorize(lightRed, level)
+		level = h.colorize(lightRed, level)
 	}
 
 	attrs, err := h.computeAttrs(ctx, r)
@@ -86,25 +101,69 @@ func (h *UserHandler) Handle(ctx context.Context, r slog.Record) error {
 		return err
 	}
 
+	var formattedAttrs string
+	if h.compact {
+		formattedAttrs, err = compactAttrs(attrs)
+	} else {
+		formattedAttrs, err = yamlAttrs(attrs)
+	}
+	if err != nil {
+		return err
+	}
+
+	line := h.colorize(lightGray, r.Time.Format(timeFormat)) + " " + level + " " + h.colorize(white, r.Message)
+	if formattedAttrs != "" {
+		separator := "\n"
+		if h.compact {
+			separator = " "
+		}
+		line += separator + h.colorize(darkGray, formattedAttrs)
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	_, err = fmt.Fprintln(h.w, line)
+	return err
+}
+
+func yamlAttrs(attrs map[string]any) (string, error) {
+	if len(attrs) == 0 {
+		return "", nil
+	}
 	bytes, err := yaml.Marshal(attrs)
 	if err != nil {
-		return fmt.Errorf("error when marshaling attrs: %w", err)
+		return "", fmt.Errorf("error when marshaling attrs: %w", err)
 	}
-	trimmedBytes := strings.TrimSpace(string(bytes))
-
-	fmt.Println(
-		colorize(lightGray, r.Time.Format(timeFormat)),
-		level,
-		colorize(white, r.Message),
-		func() string {
-			if len(trimmedBytes) > 0 && trimmedBytes != "{}" {
-				return colorize(darkGray, "\n"+trimmedBytes)
-			}
-			return ""
-		}(),
-	)
+	return strings.TrimSpace(string(bytes)), nil
+}
 
-	return nil
+// Renders attributes as sorted key=value pairs, quoting values that would break the line up
+func compactAttrs(attrs map[string]any) (string, error) {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var value string
+		switch v := attrs[key].(type) {
+		case string:
+			value = v
+		default:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return "", fmt.Errorf("error when marshaling attrs: %w", err)
+			}
+			value = string(encoded)
+		}
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, " "), nil
 }
 
 func (h *UserHandler) Enabled(ctx context.Context, level slog.Level) bool {
@@ -112,11 +171,11 @@ func (h *UserHandler) Enabled(ctx context.Context, level slog.Level) bool {
 }
 
 func (h *UserHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return &UserHandler{h: h.h.WithAttrs(attrs), b: h.b, m: h.m}
+	return &UserHandler{h: h.h.WithAttrs(attrs), b: h.b, m: h.m, w: h.w, color: h.color, compact: h.compact}
 }
 
 func (h *UserHandler) WithGroup(name string) slog.Handler {
-	return &UserHandler{h: h.h.WithGroup(name), b: h.b, m: h.m}
+	return &UserHandler{h: h.h.WithGroup(name), b: h.b, m: h.m, w: h.w, color: h.color, compact: h.compact}
 }
 
 func suppressDefaults(
@@ -135,9 +194,9 @@ func suppressDefaults(
 	}
 }
 
-func NewUserHandler(opts *slog.HandlerOptions) *UserHandler {
+func NewUserHandler(w io.Writer, opts *UserHandlerOptions) *UserHandler {
 	if opts == nil {
-		opts = &slog.HandlerOptions{}
+		opts = &UserHandlerOptions{}
 	}
 	b := &bytes.Buffer{}
 	return &UserHandler{
@@ -147,6 +206,9 @@ func NewUserHandler(opts *slog.HandlerOptions) *UserHandler {
 			AddSource:   opts.AddSource,
 			ReplaceAttr: suppressDefaults(opts.ReplaceAttr),
 		}),
-		m: &sync.Mutex{},
+		m:       &sync.Mutex{},
+		w:       w,
+		color:   opts.Color,
+		compact: opts.Compact,
 	}
 }
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompactAttrs(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string]any
+		want  string
+	}{
+		{"none", map[string]any{}, ""},
+		{"sorted", map[string]any{"user": "alice", "driver": "flatpak"}, "driver=flatpak user=alice"},
+		{"numbers and bools", map[string]any{"code": 1.0, "reboot": true}, "code=1 reboot=true"},
+		{"spaces", map[string]any{"context": "Flatpak Update"}, `context="Flatpak Update"`},
+		{"newlines", map[string]any{"stderr": "one\ntwo"}, `stderr="one\ntwo"`},
+		{"quotes and equals", map[string]any{"cli": `a="b"`}, `cli="a=\"b\""`},
+		{"empty string", map[string]any{"stdout": ""}, `stdout=""`},
+		{"nested", map[string]any{"output": map[string]any{"code": 1.0}}, `output="{\"code\":1}"`},
+		{"list", map[string]any{"cli": []any{"flatpak", "update"}}, `cli="[\"flatpak\",\"update\"]"`},
+		{"list with spaces", map[string]any{"cli": []any{"flatpak", "update -y"}}, `cli="[\"flatpak\",\"update -y\"]"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := compactAttrs(test.attrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUserHandlerWritesToWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    UserHandlerOptions
+		want    []string
+		notWant []string
+	}{
+		{
+			"pretty",
+			UserHandlerOptions{},
+			[]string{"WARN: Update failed\ndriver: flatpak\n"},
+			[]string{"\033["},
+		},
+		{
+			"compact",
+			UserHandlerOptions{Compact: true},
+			[]string{"WARN: Update failed driver=flatpak\n"},
+			[]string{"\033["},
+		},
+		{
+			"colored",
+			UserHandlerOptions{Color: true, Compact: true},
+			[]string{"\033[93mWARN:\033[0m", "\033[90mdriver=flatpak\033[0m"},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b bytes.Buffer
+			logger := slog.New(NewUserHandler(&b, &test.opts))
+			logger.Warn("Update failed", slog.String("driver", "flatpak"))
+			logger.Debug("Not shown")
+			got := b.String()
+			for _, want := range test.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("missing %q in %q", want, got)
+				}
+			}
+			for _, notWant := range append(test.notWant, "Not shown") {
+				if strings.Contains(got, notWant) {
+					t.Errorf("unexpected %q in %q", notWant, got)
+				}
+			}
+		})
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	var b bytes.Buffer
+	file, err := os.CreateTemp(t.TempDir(), "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tests := []struct {
+		name    string
+		mode    string
+		noColor string
+		writer  io.Writer
+		want    bool
+		wantErr bool
+	}{
+		{"always", "always", "1", &b, true, false},
+		{"never", "never", "", os.Stdout, false, false},
+		{"auto, buffer", "auto", "", &b, false, false},
+		{"auto, regular file", "auto", "", file, false, false},
+		{"auto, NO_COLOR", "auto", "1", os.Stdout, false, false},
+		{"unknown", "sometimes", "", &b, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("NO_COLOR", test.noColor)
+			got, err := UseColor(test.mode, test.writer)
+			if got != test.want || (err != nil) != test.wantErr {
+				t.Errorf("got %v, %v, want %v, error %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
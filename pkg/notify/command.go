@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Runs a local command with the JSON payload on its stdin and the events in UUPD_EVENTS
+type CommandSink struct {
+	Command string
+	// Zero lets the command run as long as it takes
+	Timeout time.Duration
+}
+
+// Runs UUPD_NOTIFY_COMMAND through the shell on the UUPD_NOTIFY_COMMAND_EVENTS (default failure)
+func loadCommand(env map[string]string) (*Subscription, error) {
+	command := env["UUPD_NOTIFY_COMMAND"]
+	if command == "" {
+		return nil, nil
+	}
+	events, err := parseEvents(env["UUPD_NOTIFY_COMMAND_EVENTS"], []Event{EventFailure})
+	if err != nil {
+		return nil, fmt.Errorf("UUPD_NOTIFY_COMMAND_EVENTS: %w", err)
+	}
+	return &Subscription{Sink: CommandSink{Command: command, Timeout: 1 * time.Minute}, Events: events}, nil
+}
+
+func (sink CommandSink) Name() string {
+	return "command"
+}
+
+func (sink CommandSink) Send(payload Payload) error {
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if sink.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sink.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", sink.Command)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Env = append(os.Environ(), "UUPD_EVENTS="+strings.Join(eventNames(payload.Events), ","))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
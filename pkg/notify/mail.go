@@ -0,0 +1,257 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const sendmailPath = "/usr/sbin/sendmail"
+
+// Submission port speaking TLS right away instead of STARTTLS, see RFC 8314
+const smtpsPort = "465"
+
+// Mails a plain text summary of the run, through SMTP when Relay is set and sendmail otherwise
+type MailSink struct {
+	From string
+	To   []string
+	// host:port of the SMTP relay
+	Relay string
+	// The relay speaks TLS right away instead of offering STARTTLS, like on port 465
+	ImplicitTLS bool
+	Username    string
+	Password    string
+	// Bounds sending the mail, zero waits as long as it takes
+	Timeout time.Duration
+	// Trusted instead of the system roots when set
+	rootCAs *x509.CertPool
+}
+
+// Mails UUPD_MAIL_TO on the UUPD_MAIL_EVENTS (default failure and outdated) through
+// UUPD_SMTP_RELAY, authenticating with UUPD_SMTP_USERNAME and UUPD_SMTP_PASSWORD, or the local sendmail
+func loadMail(env map[string]string) (*Subscription, error) {
+	to := env["UUPD_MAIL_TO"]
+	if to == "" {
+		return nil, nil
+	}
+	events, err := parseEvents(env["UUPD_MAIL_EVENTS"], []Event{EventFailure, EventOutdated})
+	if err != nil {
+		return nil, fmt.Errorf("UUPD_MAIL_EVENTS: %w", err)
+	}
+	sink := MailSink{
+		From:     env["UUPD_MAIL_FROM"],
+		Relay:    env["UUPD_SMTP_RELAY"],
+		Username: env["UUPD_SMTP_USERNAME"],
+		Password: env["UUPD_SMTP_PASSWORD"],
+		Timeout:  1 * time.Minute,
+	}
+	for _, address := range strings.Split(to, ",") {
+		if strings.TrimSpace(address) != "" {
+			sink.To = append(sink.To, strings.TrimSpace(address))
+		}
+	}
+	if sink.From == "" {
+		hostname, _ := os.Hostname()
+		sink.From = "uupd@" + hostname
+	}
+	if sink.Relay != "" {
+		_, port, err := net.SplitHostPort(sink.Relay)
+		if err != nil {
+			return nil, fmt.Errorf("UUPD_SMTP_RELAY: %w", err)
+		}
+		sink.ImplicitTLS = port == smtpsPort
+	} else if _, err := os.Stat(sendmailPath); err != nil {
+		return nil, fmt.Errorf("UUPD_MAIL_TO is set but UUPD_SMTP_RELAY isn't and there is no %s", sendmailPath)
+	}
+	return &Subscription{Sink: sink, Events: events}, nil
+}
+
+func (sink MailSink) Name() string {
+	return "mail"
+}
+
+func subject(payload Payload) string {
+	var problems []string
+	for _, event := range payload.Events {
+		switch event {
+		case EventFailure:
+			problems = append(problems, "updates failed")
+		case EventOutdated:
+			problems = append(problems, "system image outdated")
+		case EventReboot:
+			problems = append(problems, "reboot pending")
+		}
+	}
+	return fmt.Sprintf("[uupd] %s: %s", payload.Hostname, strings.Join(problems, ", "))
+}
+
+// Readable summary of the run, one section per thing worth looking at
+func summary(payload Payload) string {
+	var b strings.Builder
+	result := "succeeded"
+	if !payload.Success {
+		result = "failed"
+	}
+	fmt.Fprintf(&b, "uupd run on %s %s, started %s and took %s.\n", payload.Hostname, result, payload.Start.Local().Format(time.DateTime), payload.Duration.Round(time.Second))
+	if payload.Error != "" {
+		fmt.Fprintf(&b, "%s\n", payload.Error)
+	}
+
+	if len(payload.Drivers) > 0 {
+		b.WriteString("\nDrivers:\n")
+		for _, driver := range payload.Drivers {
+			status := "ok"
+			if !driver.Success {
+				status = fmt.Sprintf("failed (%d failed commands)", driver.Failures)
+			}
+			fmt.Fprintf(&b, "  %-12s %s\n", driver.Driver, status)
+		}
+	}
+
+	if len(payload.Failures) > 0 {
+		b.WriteString("\nFailed commands:\n")
+		for _, failure := range payload.Failures {
+			fmt.Fprintf(&b, "  %s", failure.Driver)
+			if failure.User != "" {
+				fmt.Fprintf(&b, " (user %s)", failure.User)
+			}
+			fmt.Fprintf(&b, ": %s\n", failure.Context)
+			for _, output := range []string{failure.Stderr, failure.Stdout} {
+				for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+					if line != "" {
+						fmt.Fprintf(&b, "    %s\n", line)
+					}
+				}
+			}
+		}
+	}
+
+	b.WriteString("\n")
+	if !payload.BootedBuildTime.IsZero() {
+		fmt.Fprintf(&b, "Booted image: %s, built %s (%d days ago)\n", payload.BootedVersion, payload.BootedBuildTime.Local().Format(time.DateOnly), int(payload.BootedAge().Hours()/24))
+	}
+	fmt.Fprintf(&b, "Outdated: %s\n", payload.OutdatedLevel)
+	if payload.RebootRequired {
+		b.WriteString("A reboot is pending to apply updates.\n")
+	}
+	return b.String()
+}
+
+func (sink MailSink) message(payload Payload) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", sink.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(sink.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject(payload))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(summary(payload), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+func (sink MailSink) Send(payload Payload) error {
+	message := sink.message(payload)
+	if sink.Relay == "" {
+		return sink.sendmail(message)
+	}
+	return sink.smtp(message)
+}
+
+func (sink MailSink) sendmail(message []byte) error {
+	ctx := context.Background()
+	if sink.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sink.Timeout)
+		defer cancel()
+	}
+	// -t takes the recipients from the headers, -oi keeps lone dots from ending the message
+	cmd := exec.CommandContext(ctx, sendmailPath, "-t", "-oi")
+	cmd.Stdin = bytes.NewReader(message)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Does what smtp.SendMail does, with the whole conversation bound by Timeout
+func (sink MailSink) smtp(message []byte) error {
+	host, _, err := net.SplitHostPort(sink.Relay)
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: sink.Timeout}
+	tlsConfig := &tls.Config{ServerName: host, RootCAs: sink.rootCAs}
+	var conn net.Conn
+	if sink.ImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", sink.Relay, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", sink.Relay)
+	}
+	if err != nil {
+		return err
+	}
+	if sink.Timeout > 0 {
+		err = conn.SetDeadline(time.Now().Add(sink.Timeout))
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	_, encrypted := client.TLSConnectionState()
+	if ok, _ := client.Extension("STARTTLS"); ok && !encrypted {
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+		encrypted = true
+	}
+	if sink.Username != "" {
+		// PlainAuth would also send the password in cleartext to a relay on localhost
+		if !encrypted {
+			return fmt.Errorf("%s offers no STARTTLS, refusing to send the SMTP credentials unencrypted (use port %s for TLS)", sink.Relay, smtpsPort)
+		}
+		err = client.Auth(smtp.PlainAuth("", sink.Username, sink.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(sink.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range sink.To {
+		err = client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(message)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
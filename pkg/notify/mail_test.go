@@ -0,0 +1,298 @@
+package notify
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ublue-os/uupd/drv"
+	"github.com/ublue-os/uupd/pkg/report"
+)
+
+func TestMailMessage(t *testing.T) {
+	runReport := report.New("host", "all")
+	runReport.BootedVersion = "41.20241001"
+	runReport.BootedBuildTime = time.Now().Add(-50 * 24 * time.Hour)
+	runReport.OutdatedLevel = drv.OutdatedWarn
+	runReport.SystemChecked = true
+	runReport.AddOutputs([]drv.CommandOutput{
+		{Driver: "flatpak", User: "alice", Failure: true, Context: "Flatpak User Apps", Stderr: errors.New("error: Unable to connect")},
+		{Driver: "system"},
+	})
+	runReport.Finish()
+
+	sink := MailSink{From: "uupd@host", To: []string{"root@example.com", "ops@example.com"}}
+	message := string(sink.message(Payload{Events: Events(runReport, State{}), Report: runReport}))
+	for _, want := range []string{
+		"From: uupd@host\r\n",
+		"To: root@example.com, ops@example.com\r\n",
+		"Subject: [uupd] host: updates failed, system image outdated\r\n",
+		"uupd run on host failed",
+		"  flatpak      failed (1 failed commands)\r\n",
+		"  system       ok\r\n",
+		"  flatpak (user alice): Flatpak User Apps\r\n    error: Unable to connect\r\n",
+		"Booted image: 41.20241001",
+		"(50 days ago)",
+		"Outdated: warn\r\n",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message lacks %q:\n%s", want, message)
+		}
+	}
+	if strings.Contains(message, "reboot is pending") {
+		t.Errorf("message mentions a reboot that isn't pending:\n%s", message)
+	}
+}
+
+func TestLoadMail(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantSink   bool
+		wantErr    bool
+		wantEvents []Event
+	}{
+		{"unset", map[string]string{}, false, false, nil},
+		{"relay", map[string]string{"UUPD_MAIL_TO": "a@b, c@d", "UUPD_SMTP_RELAY": "relay.lan:25"}, true, false, []Event{EventFailure, EventOutdated}},
+		{"events", map[string]string{"UUPD_MAIL_TO": "a@b", "UUPD_SMTP_RELAY": "relay.lan:25", "UUPD_MAIL_EVENTS": "reboot"}, true, false, []Event{EventReboot}},
+		{"implicit TLS", map[string]string{"UUPD_MAIL_TO": "a@b", "UUPD_SMTP_RELAY": "relay.lan:465"}, true, false, []Event{EventFailure, EventOutdated}},
+		{"relay without port", map[string]string{"UUPD_MAIL_TO": "a@b", "UUPD_SMTP_RELAY": "relay.lan"}, false, true, nil},
+		{"unknown event", map[string]string{"UUPD_MAIL_TO": "a@b", "UUPD_SMTP_RELAY": "relay.lan:25", "UUPD_MAIL_EVENTS": "always"}, false, true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscription, err := loadMail(test.env)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, test.wantErr)
+			}
+			if (subscription != nil) != test.wantSink {
+				t.Fatalf("subscription = %+v, wantSink %v", subscription, test.wantSink)
+			}
+			if subscription == nil {
+				return
+			}
+			if !slices.Equal(subscription.Events, test.wantEvents) {
+				t.Errorf("events = %v, want %v", subscription.Events, test.wantEvents)
+			}
+			sink := subscription.Sink.(MailSink)
+			if !strings.HasPrefix(sink.From, "uupd@") || len(sink.To) == 0 || sink.Timeout == 0 {
+				t.Errorf("unexpected sink %+v", sink)
+			}
+			if sink.ImplicitTLS != strings.HasSuffix(sink.Relay, ":465") {
+				t.Errorf("ImplicitTLS = %v for %s", sink.ImplicitTLS, sink.Relay)
+			}
+		})
+	}
+}
+
+// Minimal SMTP relay accepting a single mail, the DATA it got is sent to received.
+// With tlsConfig set it speaks TLS right away and accepts any AUTH PLAIN.
+func smtpStandIn(t *testing.T, tlsConfig *tls.Config) (string, <-chan string) {
+	t.Helper()
+	var listener net.Listener
+	var err error
+	if tlsConfig != nil {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 stand-in ESMTP")
+		var data strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				if tlsConfig != nil {
+					reply("250-stand-in")
+					reply("250 AUTH PLAIN")
+				} else {
+					reply("250 stand-in")
+				}
+			case strings.HasPrefix(command, "AUTH PLAIN") && tlsConfig != nil:
+				reply("235 accepted")
+			case strings.HasPrefix(command, "MAIL"), strings.HasPrefix(command, "RCPT"):
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 unknown")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestMailSendsThroughRelay(t *testing.T) {
+	relay, received := smtpStandIn(t, nil)
+	runReport := failedRun()
+	sink := MailSink{From: "uupd@host", To: []string{"root@example.com"}, Relay: relay, Timeout: 5 * time.Second}
+	err := sink.Send(Payload{Events: []Event{EventFailure}, Report: runReport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		if !strings.Contains(data, "Subject: [uupd] host: updates failed") || !strings.Contains(data, "flatpak") {
+			t.Errorf("unexpected mail:\n%s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the relay got no mail")
+	}
+}
+
+func TestMailImplicitTLS(t *testing.T) {
+	// Borrows the certificate httptest issues for 127.0.0.1
+	certificates := httptest.NewTLSServer(nil)
+	defer certificates.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(certificates.Certificate())
+
+	relay, received := smtpStandIn(t, &tls.Config{Certificates: certificates.TLS.Certificates})
+	sink := MailSink{From: "uupd@host", To: []string{"root@example.com"}, Relay: relay, ImplicitTLS: true, Username: "uupd", Password: "secret", Timeout: 5 * time.Second, rootCAs: rootCAs}
+	err := sink.Send(Payload{Events: []Event{EventFailure}, Report: failedRun()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		if !strings.Contains(data, "Subject: [uupd] host: updates failed") {
+			t.Errorf("unexpected mail:\n%s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the relay got no mail")
+	}
+}
+
+func TestMailRefusesCleartextAuth(t *testing.T) {
+	relay, received := smtpStandIn(t, nil)
+	sink := MailSink{From: "uupd@host", To: []string{"root@example.com"}, Relay: relay, Username: "uupd", Password: "secret", Timeout: 5 * time.Second}
+	err := sink.Send(Payload{Events: []Event{EventFailure}, Report: failedRun()})
+	if err == nil || !strings.Contains(err.Error(), "unencrypted") {
+		t.Fatalf("got %v, want a refusal to authenticate", err)
+	}
+	select {
+	case data := <-received:
+		t.Errorf("mail sent without authenticating:\n%s", data)
+	default:
+	}
+}
+
+func TestMailRelayTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// Accepts connections but never greets
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	sink := MailSink{From: "uupd@host", To: []string{"root@example.com"}, Relay: listener.Addr().String(), Timeout: 200 * time.Millisecond}
+	start := time.Now()
+	err = sink.Send(Payload{Events: []Event{EventFailure}, Report: failedRun()})
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Send took %s despite a 200ms timeout", elapsed)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(*report.Report)
+		previous State
+		want     []Event
+	}{
+		{"success", func(r *report.Report) {}, State{}, nil},
+		{"stopped early", func(r *report.Report) { r.Error = "Hardware checks failed" }, State{}, []Event{EventFailure}},
+		{"failure again", func(r *report.Report) { r.Error = "failed" }, State{OutdatedLevel: drv.OutdatedWarn}, []Event{EventFailure}},
+		{"notify level", func(r *report.Report) { r.OutdatedLevel = drv.OutdatedNotify }, State{}, []Event{EventOutdated}},
+		{"still outdated", func(r *report.Report) { r.OutdatedLevel = drv.OutdatedNotify }, State{OutdatedLevel: drv.OutdatedNotify}, nil},
+		{"more outdated", func(r *report.Report) { r.OutdatedLevel = drv.OutdatedWarn }, State{OutdatedLevel: drv.OutdatedNotify}, []Event{EventOutdated}},
+		{"updated, then outdated again", func(r *report.Report) { r.OutdatedLevel = drv.OutdatedNotify }, State{OutdatedLevel: drv.OutdatedNone}, []Event{EventOutdated}},
+		{"reboot", func(r *report.Report) { r.RebootRequired = true }, State{}, []Event{EventReboot}},
+		{"reboot still pending", func(r *report.Report) { r.RebootRequired = true }, State{RebootRequired: true}, nil},
+		{"everything", func(r *report.Report) {
+			r.Error = "failed"
+			r.OutdatedLevel = drv.OutdatedForce
+			r.RebootRequired = true
+		}, State{}, []Event{EventFailure, EventOutdated, EventReboot}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runReport := report.New("host", "all")
+			runReport.SystemChecked = true
+			test.modify(runReport)
+			runReport.Finish()
+			if got := Events(runReport, test.previous); !slices.Equal(got, test.want) {
+				t.Errorf("Events() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStateNext(t *testing.T) {
+	previous := State{OutdatedLevel: drv.OutdatedWarn, RebootRequired: true}
+
+	stopped := report.New("host", "all")
+	stopped.Error = "Failed acquiring lock"
+	stopped.Finish()
+	if got := previous.Next(stopped); got != previous {
+		t.Errorf("run stopped before checking the system changed the state: %+v", got)
+	}
+	if got := Events(stopped, State{}); !slices.Equal(got, []Event{EventFailure}) {
+		t.Errorf("Events() = %v for a run that didn't check the system", got)
+	}
+
+	updated := report.New("host", "all")
+	updated.SystemChecked = true
+	updated.Finish()
+	if got := previous.Next(updated); got != (State{}) {
+		t.Errorf("got %+v after updating", got)
+	}
+}
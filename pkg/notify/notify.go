@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ublue-os/uupd/drv"
+	"github.com/ublue-os/uupd/pkg/report"
+)
+
+// Outdated level and pending reboot of the last run, written after every run
+const statePath = "/var/lib/uupd/notify.json"
+
+// Something about a run worth telling an admin
+type Event string
+
+const (
+	// Any driver failed or the run stopped early
+	EventFailure Event = "failure"
+	// The booted image is old enough to warrant a notification
+	EventOutdated Event = "outdated"
+	// Updates wait for a reboot to be applied
+	EventReboot Event = "reboot"
+)
+
+func parseEvents(spec string, defaults []Event) ([]Event, error) {
+	if strings.TrimSpace(spec) == "" {
+		return defaults, nil
+	}
+	var events []Event
+	for _, name := range strings.Split(spec, ",") {
+		event := Event(strings.TrimSpace(name))
+		switch event {
+		case EventFailure, EventOutdated, EventReboot:
+			events = append(events, event)
+		default:
+			return nil, fmt.Errorf("unknown event %q, expected %s, %s or %s", event, EventFailure, EventOutdated, EventReboot)
+		}
+	}
+	return events, nil
+}
+
+func eventNames(events []Event) []string {
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = string(event)
+	}
+	return names
+}
+
+// What earlier runs found, outdated and reboot events only fire when it changes
+// so that they aren't repeated on every timer run until the admin acts
+type State struct {
+	OutdatedLevel  drv.OutdatedLevel `json:"outdated_level"`
+	RebootRequired bool              `json:"reboot_required"`
+}
+
+// Returns an empty state when no run has been reported yet
+func LoadState() (State, error) {
+	var state State
+	content, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(content, &state)
+	return state, err
+}
+
+func SaveState(state State) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(statePath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath, content, 0644)
+}
+
+// The state after the run, runs that stopped before checking the system leave it alone
+func (state State) Next(runReport *report.Report) State {
+	if !runReport.SystemChecked {
+		return state
+	}
+	return State{OutdatedLevel: runReport.OutdatedLevel, RebootRequired: runReport.RebootRequired}
+}
+
+// Events raised by a finished run: every failure, the image becoming more outdated
+// and a reboot becoming pending since the previous run
+func Events(runReport *report.Report, previous State) []Event {
+	var events []Event
+	if !runReport.Success {
+		events = append(events, EventFailure)
+	}
+	if !runReport.SystemChecked {
+		return events
+	}
+	if runReport.OutdatedLevel >= drv.OutdatedNotify && runReport.OutdatedLevel > previous.OutdatedLevel {
+		events = append(events, EventOutdated)
+	}
+	if runReport.RebootRequired && !previous.RebootRequired {
+		events = append(events, EventReboot)
+	}
+	return events
+}
+
+// What sinks get to send, the report's fields are inlined next to the events
+type Payload struct {
+	Events []Event `json:"events"`
+	*report.Report
+}
+
+// Somewhere to deliver run results
+type Sink interface {
+	Name() string
+	Send(payload Payload) error
+}
+
+// A sink along with the events it wants to hear about
+type Subscription struct {
+	Sink   Sink
+	Events []Event
+}
+
+// Builds the sinks configured in the environment, a misconfigured sink is left out
+// and its error returned along with the others
+func Subscriptions(env map[string]string) ([]Subscription, error) {
+	var subscriptions []Subscription
+	var errs []error
+	for _, load := range []func(map[string]string) (*Subscription, error){loadWebhook, loadCommand, loadMail} {
+		subscription, err := load(env)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if subscription != nil {
+			subscriptions = append(subscriptions, *subscription)
+		}
+	}
+	return subscriptions, errors.Join(errs...)
+}
+
+// Sends the run's events to every sink subscribed to at least one of them
+func Dispatch(subscriptions []Subscription, runReport *report.Report, previous State) error {
+	events := Events(runReport, previous)
+	var errs []error
+	for _, subscription := range subscriptions {
+		var matched []Event
+		for _, event := range events {
+			if slices.Contains(subscription.Events, event) {
+				matched = append(matched, event)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		err := subscription.Sink.Send(Payload{Events: matched, Report: runReport})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", subscription.Sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// POSTs the run report to a URL, as JSON unless a body template is given
+type WebhookSink struct {
+	URL     string
+	Headers http.Header
+	// Renders the body from the Payload, nil sends the payload as JSON
+	Body   *template.Template
+	Client *http.Client
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		content, err := json.Marshal(value)
+		return string(content), err
+	},
+	"join": func(events []Event, sep string) string {
+		return strings.Join(eventNames(events), sep)
+	},
+}
+
+// Headers are given as "Name: value" pairs separated by semicolons
+func parseHeaders(spec string) (http.Header, error) {
+	headers := http.Header{}
+	for _, field := range strings.Split(spec, ";") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(field, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", strings.TrimSpace(field))
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// Sends to UUPD_WEBHOOK_URL with UUPD_WEBHOOK_HEADERS, on the UUPD_WEBHOOK_EVENTS (default failure).
+// UUPD_WEBHOOK_TEMPLATE points to a text/template file rendering the body.
+func loadWebhook(env map[string]string) (*Subscription, error) {
+	url := env["UUPD_WEBHOOK_URL"]
+	if url == "" {
+		return nil, nil
+	}
+	events, err := parseEvents(env["UUPD_WEBHOOK_EVENTS"], []Event{EventFailure})
+	if err != nil {
+		return nil, fmt.Errorf("UUPD_WEBHOOK_EVENTS: %w", err)
+	}
+	headers, err := parseHeaders(env["UUPD_WEBHOOK_HEADERS"])
+	if err != nil {
+		return nil, fmt.Errorf("UUPD_WEBHOOK_HEADERS: %w", err)
+	}
+	sink := &WebhookSink{URL: url, Headers: headers, Client: &http.Client{Timeout: 30 * time.Second}}
+
+	path := env["UUPD_WEBHOOK_TEMPLATE"]
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("UUPD_WEBHOOK_TEMPLATE: %w", err)
+		}
+		sink.Body, err = template.New(path).Funcs(templateFuncs).Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("UUPD_WEBHOOK_TEMPLATE: %w", err)
+		}
+	}
+	return &Subscription{Sink: sink, Events: events}, nil
+}
+
+func (sink WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (sink WebhookSink) Send(payload Payload) error {
+	var body bytes.Buffer
+	contentType := "application/json"
+	if sink.Body != nil {
+		err := sink.Body.Execute(&body, payload)
+		if err != nil {
+			return err
+		}
+		contentType = "text/plain; charset=utf-8"
+	} else {
+		err := json.NewEncoder(&body).Encode(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequest(http.MethodPost, sink.URL, &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("User-Agent", "uupd")
+	for name, values := range sink.Headers {
+		request.Header[name] = values
+	}
+
+	client := sink.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("POST %s: %s", sink.URL, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ublue-os/uupd/drv"
+	"github.com/ublue-os/uupd/pkg/report"
+)
+
+type webhookRequest struct {
+	method  string
+	headers http.Header
+	body    string
+}
+
+// Records the requests it gets, answering with status
+func webhookStandIn(t *testing.T, status int) (*httptest.Server, *[]webhookRequest) {
+	t.Helper()
+	var requests []webhookRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading request body: %v", err)
+		}
+		requests = append(requests, webhookRequest{method: r.Method, headers: r.Header.Clone(), body: string(body)})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func failedRun() *report.Report {
+	runReport := report.New("host", "all")
+	runReport.SystemChecked = true
+	runReport.AddOutputs([]drv.CommandOutput{
+		{Driver: "flatpak", Failure: true, Context: "Flatpak Update"},
+		{Driver: "system", RebootRequired: true},
+	})
+	runReport.Finish()
+	return runReport
+}
+
+func TestWebhookSendsJSONReport(t *testing.T) {
+	server, requests := webhookStandIn(t, http.StatusOK)
+	subscriptions, err := Subscriptions(map[string]string{
+		"UUPD_WEBHOOK_URL":     server.URL,
+		"UUPD_WEBHOOK_HEADERS": "Authorization: Bearer secret; X-Fleet: lab",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Dispatch(subscriptions, failedRun(), State{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+	request := (*requests)[0]
+	if request.method != http.MethodPost {
+		t.Errorf("method = %s, want POST", request.method)
+	}
+	for name, want := range map[string]string{
+		"Authorization": "Bearer secret",
+		"X-Fleet":       "lab",
+		"Content-Type":  "application/json",
+		"User-Agent":    "uupd",
+	} {
+		if got := request.headers.Get(name); got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+
+	var payload struct {
+		Events   []Event `json:"events"`
+		Hostname string  `json:"hostname"`
+		Success  bool    `json:"success"`
+		Failures []struct {
+			Driver string `json:"driver"`
+		} `json:"failures"`
+	}
+	err = json.Unmarshal([]byte(request.body), &payload)
+	if err != nil {
+		t.Fatalf("body isn't JSON: %v\n%s", err, request.body)
+	}
+	// Only failure is subscribed to by default, reboot is left out
+	if len(payload.Events) != 1 || payload.Events[0] != EventFailure {
+		t.Errorf("events = %v, want [failure]", payload.Events)
+	}
+	if payload.Hostname != "host" || payload.Success || len(payload.Failures) != 1 || payload.Failures[0].Driver != "flatpak" {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
+
+func TestWebhookRendersTemplate(t *testing.T) {
+	server, requests := webhookStandIn(t, http.StatusNoContent)
+	path := filepath.Join(t.TempDir(), "webhook.tmpl")
+	err := os.WriteFile(path, []byte(`{"text": "{{.Hostname}}: {{join .Events ", "}}, {{len .Failures}} failed"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriptions, err := Subscriptions(map[string]string{
+		"UUPD_WEBHOOK_URL":      server.URL,
+		"UUPD_WEBHOOK_EVENTS":   "failure,reboot",
+		"UUPD_WEBHOOK_HEADERS":  "Content-Type: application/json",
+		"UUPD_WEBHOOK_TEMPLATE": path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Dispatch(subscriptions, failedRun(), State{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+	want := `{"text": "host: failure, reboot, 1 failed"}`
+	if got := (*requests)[0].body; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+	if got := (*requests)[0].headers.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, the configured header should win", got)
+	}
+}
+
+func TestWebhookErrorStatus(t *testing.T) {
+	server, _ := webhookStandIn(t, http.StatusInternalServerError)
+	subscriptions, err := Subscriptions(map[string]string{"UUPD_WEBHOOK_URL": server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Dispatch(subscriptions, failedRun(), State{})
+	if err == nil {
+		t.Error("expected an error for a 500 answer")
+	}
+}
+
+func TestDispatchFiltersEvents(t *testing.T) {
+	succeeded := report.New("host", "all")
+	succeeded.AddOutputs([]drv.CommandOutput{{Driver: "flatpak"}})
+	succeeded.Finish()
+
+	outdated := report.New("host", "all")
+	outdated.SystemChecked = true
+	outdated.OutdatedLevel = drv.OutdatedWarn
+	outdated.Finish()
+
+	rebooting := report.New("host", "all")
+	rebooting.SystemChecked = true
+	rebooting.RebootRequired = true
+	rebooting.Finish()
+
+	tests := []struct {
+		name     string
+		events   string
+		report   *report.Report
+		previous State
+		want     int
+	}{
+		{"failure subscribed, run failed", "failure", failedRun(), State{}, 1},
+		{"failure subscribed, run succeeded", "failure", succeeded, State{}, 0},
+		{"outdated subscribed, image outdated", "outdated", outdated, State{}, 1},
+		{"outdated subscribed, already notified", "outdated", outdated, State{OutdatedLevel: drv.OutdatedWarn}, 0},
+		{"outdated subscribed, run failed", "outdated", failedRun(), State{}, 0},
+		{"reboot subscribed, reboot pending", "reboot", rebooting, State{}, 1},
+		{"reboot subscribed, already notified", "reboot", rebooting, State{RebootRequired: true}, 0},
+		{"reboot subscribed, nothing staged", "reboot", succeeded, State{}, 0},
+		{"default events, reboot pending", "", rebooting, State{}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := webhookStandIn(t, http.StatusOK)
+			subscriptions, err := Subscriptions(map[string]string{"UUPD_WEBHOOK_URL": server.URL, "UUPD_WEBHOOK_EVENTS": test.events})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Dispatch(subscriptions, test.report, test.previous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(*requests) != test.want {
+				t.Errorf("got %d requests, want %d", len(*requests), test.want)
+			}
+		})
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []Event
+		wantErr bool
+	}{
+		{"", []Event{EventFailure}, false},
+		{"failure, outdated,reboot", []Event{EventFailure, EventOutdated, EventReboot}, false},
+		{"reboot", []Event{EventReboot}, false},
+		{"success", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parseEvents(test.spec, []Event{EventFailure})
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseEvents(%q) error = %v, wantErr %v", test.spec, err, test.wantErr)
+			continue
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("parseEvents(%q) = %v, want %v", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := parseHeaders("Authorization: Bearer a:b; X-One: 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers.Get("Authorization") != "Bearer a:b" || headers.Get("X-One") != "1" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+	_, err = parseHeaders("no colon")
+	if err == nil {
+		t.Error("expected an error for a header without a colon")
+	}
+}
@@ -0,0 +1,104 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the file written into node_exporter's textfile collector directory
+const textfileName = "uupd.prom"
+
+func boolMetric(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+type metricWriter struct {
+	b strings.Builder
+}
+
+func (w *metricWriter) header(name string, kind string, help string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w *metricWriter) sample(name string, labels string, value any) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(&w.b, "%s%s %v\n", name, labels, value)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Renders the report in the Prometheus text exposition format
+func (report Report) Metrics() string {
+	var w metricWriter
+
+	w.header("uupd_last_run_timestamp_seconds", "gauge", "When the last uupd run finished.")
+	w.sample("uupd_last_run_timestamp_seconds", "", report.End.Unix())
+	w.header("uupd_last_run_duration_seconds", "gauge", "How long the last uupd run took.")
+	w.sample("uupd_last_run_duration_seconds", "", fmt.Sprintf("%.3f", report.Duration.Seconds()))
+	w.header("uupd_last_run_success", "gauge", "Whether the last uupd run succeeded.")
+	w.sample("uupd_last_run_success", fmt.Sprintf(`phase="%s"`, escapeLabel(report.Phase)), boolMetric(report.Success))
+
+	w.header("uupd_driver_success", "gauge", "Whether each driver succeeded during the last run.")
+	for _, result := range report.Drivers {
+		w.sample("uupd_driver_success", fmt.Sprintf(`driver="%s"`, escapeLabel(result.Driver)), boolMetric(result.Success))
+	}
+	w.header("uupd_driver_failures", "gauge", "Failed commands of each driver during the last run.")
+	for _, result := range report.Drivers {
+		w.sample("uupd_driver_failures", fmt.Sprintf(`driver="%s"`, escapeLabel(result.Driver)), result.Failures)
+	}
+
+	w.header("uupd_pending_updates", "gauge", "Updates found before the last run started.")
+	kinds := make([]string, 0, len(report.PendingUpdates))
+	for kind := range report.PendingUpdates {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		w.sample("uupd_pending_updates", fmt.Sprintf(`kind="%s"`, escapeLabel(kind)), report.PendingUpdates[kind])
+	}
+
+	if !report.BootedBuildTime.IsZero() {
+		w.header("uupd_booted_image_build_timestamp_seconds", "gauge", "Build time of the booted system image.")
+		w.sample("uupd_booted_image_build_timestamp_seconds", fmt.Sprintf(`version="%s"`, escapeLabel(report.BootedVersion)), report.BootedBuildTime.Unix())
+		w.header("uupd_booted_image_age_seconds", "gauge", "Age of the booted system image.")
+		w.sample("uupd_booted_image_age_seconds", "", fmt.Sprintf("%.0f", report.BootedAge().Seconds()))
+	}
+	w.header("uupd_outdated_level", "gauge", "How outdated the system is: 0 none, 1 notify, 2 warn, 3 force.")
+	w.sample("uupd_outdated_level", "", int(report.OutdatedLevel))
+	w.header("uupd_reboot_pending", "gauge", "Whether updates are waiting for a reboot.")
+	w.sample("uupd_reboot_pending", "", boolMetric(report.RebootRequired))
+
+	return w.b.String()
+}
+
+// Atomically replaces uupd.prom in the textfile collector directory,
+// so that node_exporter never reads a partial file
+func (report Report) WriteTextfile(dir string) error {
+	temp, err := os.CreateTemp(dir, "."+textfileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.WriteString(report.Metrics())
+	if err == nil {
+		err = temp.Chmod(0644)
+	}
+	closeErr := temp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(temp.Name(), filepath.Join(dir, textfileName))
+}
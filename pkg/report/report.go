@@ -0,0 +1,100 @@
+package report
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/ublue-os/uupd/drv"
+)
+
+// Outcome of every driver that ran
+type DriverResult struct {
+	Driver   string `json:"driver"`
+	Success  bool   `json:"success"`
+	Failures int    `json:"failures"`
+}
+
+// A command that failed during the run
+type Failure struct {
+	Driver  string `json:"driver"`
+	User    string `json:"user,omitempty"`
+	Context string `json:"context"`
+	Stdout  string `json:"stdout,omitempty"`
+	Stderr  string `json:"stderr,omitempty"`
+}
+
+// What a single `uupd` run did, filled in as the run goes
+type Report struct {
+	Hostname string        `json:"hostname"`
+	Phase    string        `json:"phase,omitempty"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"-"`
+	Success  bool          `json:"success"`
+	// Why the run stopped early, if it did
+	Error   string         `json:"error,omitempty"`
+	Drivers []DriverResult `json:"drivers"`
+	// Updates Check() found before the run, per kind (system, firmware)
+	PendingUpdates map[string]int `json:"pending_updates"`
+	BootedVersion  string         `json:"booted_version,omitempty"`
+	// Build time of the booted image, zero if unknown
+	BootedBuildTime time.Time         `json:"booted_build_time"`
+	OutdatedLevel   drv.OutdatedLevel `json:"outdated_level"`
+	// Set by updates of this run as well as deployments staged before
+	RebootRequired bool `json:"reboot_required"`
+	// Whether the run got as far as checking the system, the outdated level and pending reboot are only known then
+	SystemChecked bool      `json:"-"`
+	Changelog     string    `json:"changelog,omitempty"`
+	Failures      []Failure `json:"failures"`
+}
+
+func New(hostname string, phase string) *Report {
+	return &Report{
+		Hostname:       hostname,
+		Phase:          phase,
+		Start:          time.Now(),
+		Drivers:        []DriverResult{},
+		PendingUpdates: map[string]int{},
+		Failures:       []Failure{},
+	}
+}
+
+// Counts the commands every driver ran, failures are kept along with their output
+func (report *Report) AddOutputs(outputs []drv.CommandOutput) {
+	for _, output := range outputs {
+		index := slices.IndexFunc(report.Drivers, func(result DriverResult) bool { return result.Driver == output.Driver })
+		if index == -1 {
+			report.Drivers = append(report.Drivers, DriverResult{Driver: output.Driver, Success: true})
+			index = len(report.Drivers) - 1
+		}
+		if output.RebootRequired {
+			report.RebootRequired = true
+		}
+		if !output.Failure {
+			continue
+		}
+		report.Drivers[index].Success = false
+		report.Drivers[index].Failures++
+		failure := Failure{Driver: output.Driver, User: output.User, Context: output.Context, Stdout: output.Stdout}
+		if output.Stderr != nil {
+			failure.Stderr = output.Stderr.Error()
+		}
+		report.Failures = append(report.Failures, failure)
+	}
+}
+
+// Stamps the end of the run, it failed if it stopped early or any driver did
+func (report *Report) Finish() {
+	report.End = time.Now()
+	report.Duration = report.End.Sub(report.Start)
+	report.Success = report.Error == "" && len(report.Failures) == 0
+	sort.Slice(report.Drivers, func(i, j int) bool { return report.Drivers[i].Driver < report.Drivers[j].Driver })
+}
+
+func (report Report) BootedAge() time.Duration {
+	if report.BootedBuildTime.IsZero() {
+		return 0
+	}
+	return report.End.Sub(report.BootedBuildTime)
+}
@@ -0,0 +1,169 @@
+package report
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ublue-os/uupd/drv"
+)
+
+func TestAddOutputs(t *testing.T) {
+	tests := []struct {
+		name        string
+		outputs     []drv.CommandOutput
+		runError    string
+		wantSuccess bool
+		wantDrivers []DriverResult
+		wantReboot  bool
+	}{
+		{
+			"nothing ran",
+			nil, "", true,
+			[]DriverResult{}, false,
+		},
+		{
+			"all succeeded",
+			[]drv.CommandOutput{{Driver: "system", RebootRequired: true}, {Driver: "flatpak"}, {Driver: "flatpak"}},
+			"", true,
+			[]DriverResult{{Driver: "flatpak", Success: true}, {Driver: "system", Success: true}},
+			true,
+		},
+		{
+			"one driver failed",
+			[]drv.CommandOutput{{Driver: "flatpak", Failure: true}, {Driver: "flatpak"}, {Driver: "brew", Failure: true}, {Driver: "flatpak", Failure: true}},
+			"", false,
+			[]DriverResult{{Driver: "brew", Failures: 1}, {Driver: "flatpak", Failures: 2}},
+			false,
+		},
+		{
+			"stopped early",
+			[]drv.CommandOutput{{Driver: "system"}},
+			"Outside of maintenance windows", false,
+			[]DriverResult{{Driver: "system", Success: true}},
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := New("host", "all")
+			report.AddOutputs(test.outputs)
+			report.Error = test.runError
+			report.Finish()
+			if report.Success != test.wantSuccess {
+				t.Errorf("Success: got %v, want %v", report.Success, test.wantSuccess)
+			}
+			if report.RebootRequired != test.wantReboot {
+				t.Errorf("RebootRequired: got %v, want %v", report.RebootRequired, test.wantReboot)
+			}
+			if len(report.Drivers) != len(test.wantDrivers) {
+				t.Fatalf("Drivers: got %+v, want %+v", report.Drivers, test.wantDrivers)
+			}
+			for i, want := range test.wantDrivers {
+				if report.Drivers[i] != want {
+					t.Errorf("Drivers[%d]: got %+v, want %+v", i, report.Drivers[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestAddOutputsKeepsFailures(t *testing.T) {
+	report := New("host", "all")
+	report.AddOutputs([]drv.CommandOutput{
+		{Driver: "flatpak", User: "alice", Context: "Flatpak User Update", Failure: true, Stdout: "out", Stderr: errors.New("exit status 1")},
+		{Driver: "system", Context: "System Update"},
+	})
+	want := Failure{Driver: "flatpak", User: "alice", Context: "Flatpak User Update", Stdout: "out", Stderr: "exit status 1"}
+	if len(report.Failures) != 1 || report.Failures[0] != want {
+		t.Errorf("got %+v, want %+v", report.Failures, want)
+	}
+}
+
+func finishedRun() Report {
+	start := time.Unix(1700000000, 0)
+	return Report{
+		Hostname:        "host",
+		Phase:           "all",
+		Start:           start,
+		End:             start.Add(90 * time.Second),
+		Duration:        90 * time.Second,
+		Drivers:         []DriverResult{{Driver: "flatpak", Failures: 1}, {Driver: "system", Success: true}},
+		PendingUpdates:  map[string]int{"system": 1, "firmware": 2},
+		BootedVersion:   `41."beta"`,
+		BootedBuildTime: start.Add(-48 * time.Hour),
+		OutdatedLevel:   drv.OutdatedNotify,
+		RebootRequired:  true,
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := finishedRun().Metrics()
+	for _, line := range []string{
+		"# TYPE uupd_last_run_timestamp_seconds gauge",
+		"uupd_last_run_timestamp_seconds 1700000090",
+		"uupd_last_run_duration_seconds 90.000",
+		`uupd_last_run_success{phase="all"} 0`,
+		`uupd_driver_success{driver="flatpak"} 0`,
+		`uupd_driver_success{driver="system"} 1`,
+		`uupd_driver_failures{driver="flatpak"} 1`,
+		"uupd_pending_updates{kind=\"firmware\"} 2\nuupd_pending_updates{kind=\"system\"} 1\n",
+		`uupd_booted_image_build_timestamp_seconds{version="41.\"beta\""} 1699827200`,
+		"uupd_booted_image_age_seconds 172890",
+		"uupd_outdated_level 1",
+		"uupd_reboot_pending 1",
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("missing %q in:\n%s", line, metrics)
+		}
+	}
+
+	run := finishedRun()
+	run.BootedBuildTime = time.Time{}
+	if metrics := run.Metrics(); strings.Contains(metrics, "uupd_booted_image") {
+		t.Errorf("image age reported without a build time:\n%s", metrics)
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	dir := t.TempDir()
+	run := finishedRun()
+	err := run.WriteTextfile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = run.WriteTextfile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != textfileName {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+	path := filepath.Join(dir, textfileName)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != run.Metrics() {
+		t.Errorf("got %q, want %q", content, run.Metrics())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode %v, want 0644", info.Mode().Perm())
+	}
+
+	if err := run.WriteTextfile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("writing into a missing directory succeeded")
+	}
+}
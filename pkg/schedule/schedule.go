@@ -0,0 +1,228 @@
+package schedule
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit status of a run that was skipped because it is outside of the maintenance windows,
+// EX_TEMPFAIL so that systemd can tell it apart from a failure
+const ExitOutsideWindow = 75
+
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// A recurring slot like "Mon-Fri 02:00-05:00".
+// Slots ending before they start run past midnight, the days are the ones they start on.
+type Window struct {
+	spec string
+	days [7]bool
+	// Minutes after midnight
+	start int
+	end   int
+}
+
+// Updates are only allowed inside of any of the windows and outside of all blackouts.
+// No windows means any time is fine.
+type Policy struct {
+	Windows   []Window
+	Blackouts []Window
+}
+
+type interval struct {
+	start time.Time
+	end   time.Time
+}
+
+func parseDay(day string) (int, error) {
+	for i, name := range dayNames {
+		if strings.EqualFold(day, name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown day: %s", day)
+}
+
+func parseClock(clock string) (int, error) {
+	hours, minutes, found := strings.Cut(clock, ":")
+	if !found {
+		return 0, fmt.Errorf("Invalid time, expected HH:MM: %s", clock)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time, expected HH:MM: %s", clock)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || h < 0 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("Invalid time, expected HH:MM: %s", clock)
+	}
+	return h*60 + m, nil
+}
+
+// Parses "[days ]HH:MM-HH:MM", days being a comma separated list of days or day ranges (Mon-Fri,Sun)
+func ParseWindow(spec string) (Window, error) {
+	window := Window{spec: strings.TrimSpace(spec)}
+	fields := strings.Fields(window.spec)
+	var clocks string
+	switch len(fields) {
+	case 1:
+		clocks = fields[0]
+		window.days = [7]bool{true, true, true, true, true, true, true}
+	case 2:
+		clocks = fields[1]
+		for _, days := range strings.Split(fields[0], ",") {
+			from, to, isRange := strings.Cut(days, "-")
+			first, err := parseDay(from)
+			if err != nil {
+				return window, err
+			}
+			last := first
+			if isRange {
+				last, err = parseDay(to)
+				if err != nil {
+					return window, err
+				}
+			}
+			// Ranges like Fri-Mon wrap around the week
+			for day := first; ; day = (day + 1) % 7 {
+				window.days[day] = true
+				if day == last {
+					break
+				}
+			}
+		}
+	default:
+		return window, fmt.Errorf("Invalid window, expected '[days ]HH:MM-HH:MM': %s", spec)
+	}
+
+	start, end, found := strings.Cut(clocks, "-")
+	if !found {
+		return window, fmt.Errorf("Invalid window, expected '[days ]HH:MM-HH:MM': %s", spec)
+	}
+	var err error
+	window.start, err = parseClock(start)
+	if err != nil {
+		return window, err
+	}
+	window.end, err = parseClock(end)
+	if err != nil {
+		return window, err
+	}
+	if window.start == window.end {
+		return window, fmt.Errorf("Window is empty: %s", spec)
+	}
+	return window, nil
+}
+
+func (window Window) String() string {
+	return window.spec
+}
+
+// Occurrences of the window starting between a day before from and days after it
+func (window Window) intervals(from time.Time, days int) []interval {
+	var intervals []interval
+	year, month, day := from.Date()
+	for offset := -1; offset <= days; offset++ {
+		if !window.days[time.Date(year, month, day+offset, 0, 0, 0, 0, from.Location()).Weekday()] {
+			continue
+		}
+		endOffset := offset
+		if window.end <= window.start {
+			endOffset++
+		}
+		// Wall clock times, so that DST changes don't shift the window
+		intervals = append(intervals, interval{
+			start: time.Date(year, month, day+offset, 0, window.start, 0, 0, from.Location()),
+			end:   time.Date(year, month, day+endOffset, 0, window.end, 0, 0, from.Location()),
+		})
+	}
+	return intervals
+}
+
+func (window Window) Contains(t time.Time) bool {
+	for _, interval := range window.intervals(t, 0) {
+		if !t.Before(interval.start) && t.Before(interval.end) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseWindows(value string) ([]Window, error) {
+	var windows []Window
+	for _, spec := range strings.Split(value, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		window, err := ParseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// Reads the semicolon separated windows in UUPD_MAINTENANCE_WINDOWS and UUPD_BLACKOUT_WINDOWS
+func ParsePolicy(env map[string]string) (Policy, error) {
+	var policy Policy
+	var err error
+	policy.Windows, err = parseWindows(env["UUPD_MAINTENANCE_WINDOWS"])
+	if err != nil {
+		return policy, fmt.Errorf("UUPD_MAINTENANCE_WINDOWS: %w", err)
+	}
+	policy.Blackouts, err = parseWindows(env["UUPD_BLACKOUT_WINDOWS"])
+	if err != nil {
+		return policy, fmt.Errorf("UUPD_BLACKOUT_WINDOWS: %w", err)
+	}
+	return policy, nil
+}
+
+func (policy Policy) Empty() bool {
+	return len(policy.Windows) == 0 && len(policy.Blackouts) == 0
+}
+
+func (policy Policy) Allowed(t time.Time) bool {
+	for _, blackout := range policy.Blackouts {
+		if blackout.Contains(t) {
+			return false
+		}
+	}
+	if len(policy.Windows) == 0 {
+		return true
+	}
+	for _, window := range policy.Windows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Earliest time from t on at which updates are allowed, false if there is none within the next week
+func (policy Policy) Next(t time.Time) (time.Time, bool) {
+	if policy.Allowed(t) {
+		return t, true
+	}
+	// Updates can only become allowed when a window opens or a blackout ends
+	var candidates []time.Time
+	for _, window := range policy.Windows {
+		for _, interval := range window.intervals(t, 8) {
+			candidates = append(candidates, interval.start)
+		}
+	}
+	for _, blackout := range policy.Blackouts {
+		for _, interval := range blackout.intervals(t, 8) {
+			candidates = append(candidates, interval.end)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	for _, candidate := range candidates {
+		if candidate.After(t) && policy.Allowed(candidate) {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,144 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// 2025-01-06 is a Monday
+func at(day int, clock string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", "2025-01-06 "+clock)
+	if err != nil {
+		panic(err)
+	}
+	return t.AddDate(0, 0, day)
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"02:00-05:00", false},
+		{"Mon-Fri 02:00-05:00", false},
+		{"sat,SUN 22:00-06:00", false},
+		{"Fri-Mon 00:00-24:00", false},
+		{"Mon-Fri", true},
+		{"Mon Tue 02:00-05:00", true},
+		{"Someday 02:00-05:00", true},
+		{"02:00", true},
+		{"2-5", true},
+		{"25:00-05:00", true},
+		{"02:60-05:00", true},
+		{"24:30-05:00", true},
+		{"02:00-02:00", true},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			_, err := ParseWindow(test.spec)
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	tests := []struct {
+		spec string
+		t    time.Time
+		want bool
+	}{
+		{"Mon-Fri 02:00-05:00", at(0, "02:00"), true},
+		{"Mon-Fri 02:00-05:00", at(0, "04:59"), true},
+		{"Mon-Fri 02:00-05:00", at(0, "05:00"), false},
+		{"Mon-Fri 02:00-05:00", at(5, "03:00"), false},
+		// Runs past midnight, belongs to the day it starts on
+		{"Sun 22:00-06:00", at(0, "05:00"), true},
+		{"Sun 22:00-06:00", at(0, "22:30"), false},
+		{"Sun 22:00-06:00", at(6, "23:00"), true},
+		{"Fri-Mon 12:00-13:00", at(5, "12:30"), true},
+		{"Fri-Mon 12:00-13:00", at(1, "12:30"), false},
+		{"00:00-24:00", at(3, "23:59"), true},
+	}
+	for _, test := range tests {
+		t.Run(test.spec+" "+test.t.Format("Mon 15:04"), func(t *testing.T) {
+			window, err := ParseWindow(test.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := window.Contains(test.t); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy(map[string]string{})
+	if err != nil || !policy.Empty() {
+		t.Errorf("empty environment: got %+v, %v", policy, err)
+	}
+
+	policy, err = ParsePolicy(map[string]string{
+		"UUPD_MAINTENANCE_WINDOWS": "Mon-Fri 02:00-05:00; ;Sat,Sun 22:00-06:00;",
+		"UUPD_BLACKOUT_WINDOWS":    "Mon-Fri 08:00-18:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Windows) != 2 || len(policy.Blackouts) != 1 {
+		t.Errorf("got %d windows and %d blackouts", len(policy.Windows), len(policy.Blackouts))
+	}
+
+	for _, key := range []string{"UUPD_MAINTENANCE_WINDOWS", "UUPD_BLACKOUT_WINDOWS"} {
+		_, err = ParsePolicy(map[string]string{key: "Mon-Fri 02:00-05:00; nonsense"})
+		if err == nil {
+			t.Errorf("%s: invalid window accepted", key)
+		}
+	}
+}
+
+func TestPolicyAllowedAndNext(t *testing.T) {
+	policy, err := ParsePolicy(map[string]string{
+		"UUPD_MAINTENANCE_WINDOWS": "Mon-Fri 02:00-05:00",
+		"UUPD_BLACKOUT_WINDOWS":    "Wed 00:00-04:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		t           time.Time
+		wantAllowed bool
+		wantNext    time.Time
+	}{
+		{"inside a window", at(0, "03:00"), true, at(0, "03:00")},
+		{"after a window", at(0, "12:00"), false, at(1, "02:00")},
+		{"window opening later in a blackout", at(1, "12:00"), false, at(2, "04:00")},
+		{"inside a blackout", at(2, "03:00"), false, at(2, "04:00")},
+		{"weekend", at(5, "03:00"), false, at(7, "02:00")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := policy.Allowed(test.t); got != test.wantAllowed {
+				t.Errorf("Allowed: got %v, want %v", got, test.wantAllowed)
+			}
+			next, found := policy.Next(test.t)
+			if !found || !next.Equal(test.wantNext) {
+				t.Errorf("Next: got %v (%v), want %v", next, found, test.wantNext)
+			}
+		})
+	}
+
+	if !(Policy{}).Allowed(at(0, "12:00")) {
+		t.Error("empty policy should allow any time")
+	}
+	never, err := ParsePolicy(map[string]string{"UUPD_BLACKOUT_WINDOWS": "00:00-24:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := never.Next(at(0, "12:00")); found {
+		t.Error("Next found a slot although every day is blacked out")
+	}
+}
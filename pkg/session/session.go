@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/godbus/dbus/v5"
 	"os/exec"
+	"strconv"
 )
 
 type User struct {
@@ -11,6 +12,77 @@ type User struct {
 	Name string
 }
 
+// CPU and IO priority for the commands uupd runs, zero values leave systemd's defaults
+type Resources struct {
+	IOWeight  int
+	CPUWeight int
+	Nice      *int
+	// KiB/s, 0 if unlimited. Left to the drivers whose tools can cap downloads
+	DownloadLimit int
+}
+
+var resources Resources
+
+func ParseResources(env map[string]string) (Resources, error) {
+	var parsed Resources
+	for key, target := range map[string]*int{"UUPD_IO_WEIGHT": &parsed.IOWeight, "UUPD_CPU_WEIGHT": &parsed.CPUWeight} {
+		value, exists := env[key]
+		if !exists || value == "" {
+			continue
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 1 || weight > 10000 {
+			return Resources{}, fmt.Errorf("%s must be between 1 and 10000, got: %s", key, value)
+		}
+		*target = weight
+	}
+	value, exists := env["UUPD_NICE"]
+	if exists && value != "" {
+		nice, err := strconv.Atoi(value)
+		if err != nil || nice < -20 || nice > 19 {
+			return Resources{}, fmt.Errorf("UUPD_NICE must be between -20 and 19, got: %s", value)
+		}
+		parsed.Nice = &nice
+	}
+	value, exists = env["UUPD_DOWNLOAD_LIMIT"]
+	if exists && value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return Resources{}, fmt.Errorf("UUPD_DOWNLOAD_LIMIT must be a positive amount of KiB/s, got: %s", value)
+		}
+		parsed.DownloadLimit = limit
+	}
+	return parsed, nil
+}
+
+// Applies to every command started through RunUID and RunScoped from now on
+func SetResources(r Resources) {
+	resources = r
+}
+
+// Download speed cap in KiB/s set with SetResources, 0 if unlimited
+func DownloadLimit() int {
+	return resources.DownloadLimit
+}
+
+func (r Resources) empty() bool {
+	return r.IOWeight == 0 && r.CPUWeight == 0 && r.Nice == nil
+}
+
+func (r Resources) systemdRunArgs() []string {
+	var args []string
+	if r.IOWeight != 0 {
+		args = append(args, fmt.Sprintf("--property=IOWeight=%d", r.IOWeight))
+	}
+	if r.CPUWeight != 0 {
+		args = append(args, fmt.Sprintf("--property=CPUWeight=%d", r.CPUWeight))
+	}
+	if r.Nice != nil {
+		args = append(args, fmt.Sprintf("--nice=%d", *r.Nice))
+	}
+	return args
+}
+
 func RunUID(uid int, command []string, env map[string]string) ([]byte, error) {
 	// Just fork systemd-run, we don't need to rewrite systemd-run with dbus
 	cmdArgs := []string{
@@ -23,6 +95,7 @@ func RunUID(uid int, command []string, env map[string]string) ([]byte, error) {
 	if uid != 0 {
 		cmdArgs = append(cmdArgs, "--user")
 	}
+	cmdArgs = append(cmdArgs, resources.systemdRunArgs()...)
 	cmdArgs = append(cmdArgs, command...)
 
 	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
@@ -30,6 +103,22 @@ func RunUID(uid int, command []string, env map[string]string) ([]byte, error) {
 	return cmd.CombinedOutput()
 }
 
+// Runs a command as root in a transient scope carrying the configured resource controls,
+// or directly if there are none
+func RunScoped(command []string) ([]byte, error) {
+	cmdArgs := command
+	if !resources.empty() {
+		cmdArgs = []string{"/usr/bin/systemd-run", "--scope", "--quiet"}
+		cmdArgs = append(cmdArgs, resources.systemdRunArgs()...)
+		cmdArgs = append(cmdArgs, "--")
+		cmdArgs = append(cmdArgs, command...)
+	}
+
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+
+	return cmd.CombinedOutput()
+}
+
 func ListUsers() ([]User, error) {
 	conn, err := dbus.SystemBus()
 	if err != nil {
@@ -71,13 +160,22 @@ func ListUsers() ([]User, error) {
 }
 
 func Notify(summary string, body string) error {
+	return notify(summary, body, "normal")
+}
+
+// Like Notify, but the notification stays around until dismissed
+func NotifyCritical(summary string, body string) error {
+	return notify(summary, body, "critical")
+}
+
+func notify(summary string, body string, urgency string) error {
 	users, err := ListUsers()
 	if err != nil {
 		return err
 	}
 	for _, user := range users {
 		// we don't care if these exit
-		_, _ = RunUID(user.UID, []string{"/usr/bin/notify-send", "--app-name", "uupd", summary, body}, nil)
+		_, _ = RunUID(user.UID, []string{"/usr/bin/notify-send", "--app-name", "uupd", "--urgency", urgency, summary, body}, nil)
 	}
 	return nil
 }
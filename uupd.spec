@@ -36,6 +36,7 @@ go build -v -o %{name}
 install -Dpm 0755 %{name} %{buildroot}%{_bindir}/%{name}
 install -Dpm 644 %{name}.service %{buildroot}%{_unitdir}/%{name}.service
 install -Dpm 644 %{name}.timer %{buildroot}%{_unitdir}/%{name}.timer
+install -Dpm 644 %{name}-verify-boot.service %{buildroot}%{_unitdir}/%{name}-verify-boot.service
 install -Dpm 644 %{name}.rules %{buildroot}%{_sysconfdir}/polkit-1/rules.d/%{name}.rules
 
 %check
@@ -44,14 +45,17 @@ install -Dpm 644 %{name}.rules %{buildroot}%{_sysconfdir}/polkit-1/rules.d/%{nam
 
 %post
 %systemd_post %{name}.timer
+%systemd_post %{name}-verify-boot.service
 
 %preun
 %systemd_preun %{name}.timer
+%systemd_preun %{name}-verify-boot.service
 
 %files
 %{_bindir}/%{name}
 %{_unitdir}/%{name}.service
 %{_unitdir}/%{name}.timer
+%{_unitdir}/%{name}-verify-boot.service
 %config(noreplace) %{_sysconfdir}/polkit-1/rules.d/%{name}.rules
 
 %changelog